@@ -0,0 +1,8 @@
+package proto
+
+import _ "embed"
+
+// OpenAPI document generated from user.proto
+//
+//go:embed user.swagger.json
+var Swagger []byte
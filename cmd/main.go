@@ -1,16 +1,28 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
+	"time"
 
 	config "github.com/golang-unitied-school/useragent/config"
+	"github.com/golang-unitied-school/useragent/internal/api/docs"
 	api "github.com/golang-unitied-school/useragent/internal/api/v1"
 	dbFace "github.com/golang-unitied-school/useragent/internal/interfaces"
+	"github.com/golang-unitied-school/useragent/internal/pkg/mfa"
+	"github.com/golang-unitied-school/useragent/internal/pkg/notify"
+	"github.com/golang-unitied-school/useragent/internal/pkg/ratelimit"
+	"github.com/golang-unitied-school/useragent/internal/pkg/retention"
+	"github.com/golang-unitied-school/useragent/internal/pkg/tokens"
+	"github.com/golang-unitied-school/useragent/internal/repositories/migrations"
 	user "github.com/golang-unitied-school/useragent/internal/repositories/users"
 	"github.com/joho/godotenv"
 	"google.golang.org/grpc"
@@ -18,6 +30,13 @@ import (
 
 var appCfg config.Config
 
+var (
+	errMigrateUsage = errors.New("usage: migrate up | down | status | to <version>")
+	errNoMigrations = errors.New("database implementation has no migrations")
+	errPurgeUsage   = errors.New("usage: purge [dry-run]")
+	errNoRetention  = errors.New("RETENTION_PERIOD is not set")
+)
+
 func init() {
 
 	log.Println("get app config..")
@@ -29,25 +48,34 @@ func init() {
 
 }
 
-func initDatabase(cfg config.DatabaseConfig) dbFace.UserDataManager {
+func initDatabase(cfg config.DatabaseConfig) dbFace.DataManager {
 
-	var dbConn dbFace.UserDataManager
+	var dbConn dbFace.DataManager
 
 	//here you can describe any variant og db connections (db face implementation)
 	switch cfg.DB_TYPE {
 	case "Postgres":
 		dbConn = new(user.PGSQL)
+	case "Memory":
+		dbConn = new(user.Memory)
+	case "SQLite":
+		dbConn = new(user.SQLite)
 	default:
 		log.Fatal("Database implementation not found")
 	}
-	dsn := fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s sslmode=%s TimeZone=%s",
-		cfg.DB_HOST,
-		cfg.DB_PORT,
-		cfg.DB_NAME,
-		cfg.DB_USER,
-		cfg.DB_PASS,
-		cfg.OTHER_P["DB_SSLMODE"],
-		cfg.OTHER_P["DB_TZ"])
+
+	// SQLite opens database file from DB_NAME
+	dsn := cfg.DB_NAME
+	if cfg.DB_TYPE == "Postgres" {
+		dsn = fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s sslmode=%s TimeZone=%s",
+			cfg.DB_HOST,
+			cfg.DB_PORT,
+			cfg.DB_NAME,
+			cfg.DB_USER,
+			cfg.DB_PASS,
+			cfg.OTHER_P["DB_SSLMODE"],
+			cfg.OTHER_P["DB_TZ"])
+	}
 
 	log.Println("start db connection..")
 	dbConn.Init(dsn)
@@ -55,18 +83,155 @@ func initDatabase(cfg config.DatabaseConfig) dbFace.UserDataManager {
 	return dbConn
 }
 
+// periodically pick up new signing keys from key directory
+func watchSigningKeys(manager *tokens.Manager, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := manager.Reload(); err != nil {
+			log.Printf("error while reloading signing keys: %s", err.Error())
+		}
+	}
+}
+
+// migrate subcommand: up, down, status, to <version>
+func runMigrate(migrator *migrations.Migrator, args []string) error {
+	if migrator == nil {
+		return errNoMigrations
+	}
+
+	if len(args) == 0 {
+		return errMigrateUsage
+	}
+
+	switch args[0] {
+	case "up":
+		return migrator.Up()
+	case "down":
+		return migrator.Down()
+	case "to":
+		if len(args) < 2 {
+			return errMigrateUsage
+		}
+		version, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil {
+			return errMigrateUsage
+		}
+		return migrator.To(uint(version))
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		for _, st := range statuses {
+			applied := "pending"
+			if st.AppliedAt != nil {
+				applied = st.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", st.Version, st.Name, applied)
+		}
+		return nil
+	default:
+		return errMigrateUsage
+	}
+}
+
+// purge subcommand: erase users deleted more than RETENTION_PERIOD ago once
+func runPurge(dbConn dbFace.DataManager, cfg config.RetentionConfig, args []string) error {
+	if len(args) > 1 || (len(args) == 1 && args[0] != "dry-run") {
+		return errPurgeUsage
+	}
+
+	if cfg.RETENTION_PERIOD <= 0 {
+		return errNoRetention
+	}
+	cfg.RETENTION_DRY_RUN = cfg.RETENTION_DRY_RUN || len(args) == 1
+
+	report, err := retention.NewJob(dbConn, cfg).Purge(context.Background())
+	retention.Log(report)
+	return err
+}
+
 func main() {
 	conf := config.GetConfig()
 
 	dbConn := initDatabase(conf.DBConfig)
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err := runMigrate(dbConn.Migrator(), os.Args[2:])
+		dbConn.Close()
+		if err != nil {
+			log.Fatalf("error while migrating: %s", err.Error())
+		}
+		return
+	}
+
+	if migrator := dbConn.Migrator(); migrator != nil {
+		if err := migrator.Check(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "purge" {
+		err := runPurge(dbConn, conf.RetentionConfig, os.Args[2:])
+		dbConn.Close()
+		if err != nil {
+			log.Fatalf("error while purging deleted users: %s", err.Error())
+		}
+		return
+	}
+
+	if conf.RetentionConfig.RETENTION_PERIOD > 0 {
+		if conf.RetentionConfig.RETENTION_PERIOD < conf.AuthConfig.AUTH_RESTORE_WINDOW {
+			log.Printf("warning: RETENTION_PERIOD is shorter than AUTH_RESTORE_WINDOW, deleted users are erased before restore window ends")
+		}
+
+		retentionCtx, stopRetention := context.WithCancel(context.Background())
+		defer stopRetention()
+		go retention.NewJob(dbConn, conf.RetentionConfig).Run(retentionCtx)
+	}
+
+	tokenManager, err := tokens.NewManager(conf.TokenConfig)
+	if err != nil {
+		log.Fatalf("error while loading signing key: %s", err.Error())
+	}
+
+	if conf.TokenConfig.JWT_KEYS_DIR != "" {
+		go watchSigningKeys(tokenManager, conf.TokenConfig.JWT_KEYS_RELOAD)
+	}
+
+	mailer, err := notify.New(conf.NotifyConfig)
+	if err != nil {
+		log.Fatalf("error while configuring notifications: %s", err.Error())
+	}
+	defer mailer.Close()
+
+	mfaManager, err := mfa.NewManager(conf.MFAConfig)
+	if err != nil {
+		log.Fatalf("error while configuring MFA: %s", err.Error())
+	}
+
+	rateLimits, err := ratelimit.ParseRules(conf.RateLimitConfig.RATE_LIMIT_RULES)
+	if err != nil {
+		log.Fatalf("error while configuring rate limits: %s", err.Error())
+	}
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemory(), rateLimits)
+
 	log.Println("starting grpc server...")
 
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 
-	srv := grpc.NewServer()
-	grpcsrv := &api.UserAgent{DBConn: dbConn}
+	srv := grpc.NewServer(grpc.UnaryInterceptor(limiter.UnaryServerInterceptor()))
+	grpcsrv := &api.UserAgent{
+		DBConn:    dbConn,
+		TokenConn: dbConn,
+		Tokens:    tokenManager,
+		Auth:      conf.AuthConfig,
+		Mailer:    mailer,
+		MFA:       mfaManager,
+	}
 	api.RegisterUserAgentServer(srv, grpcsrv)
 
 	go func() {
@@ -87,10 +252,50 @@ func main() {
 		}
 	}()
 
+	gatewayConn, err := api.DialInProcess(context.Background(), srv)
+	if err != nil {
+		log.Fatalf("error while connecting gateway: %s", err.Error())
+	}
+	defer gatewayConn.Close()
+
+	gateway, err := api.NewGateway(context.Background(), gatewayConn)
+	if err != nil {
+		log.Fatalf("error while registering gateway: %s", err.Error())
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/.well-known/jwks.json", tokenManager.JWKSHandler())
+	mux.Handle("/api/", gateway)
+	mux.Handle(api.ExportPath, api.NewExportHandler(gateway, gatewayConn))
+	mux.Handle(docs.Prefix, docs.Handler())
+
+	var httpPort = "8081"
+	if conf.HTTPPort != "" {
+		httpPort = conf.HTTPPort
+	}
+	httpSrv := &http.Server{
+		Addr:              fmt.Sprintf(":%s", httpPort),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		log.Printf("starting http server on port %s", httpPort)
+		if err := httpSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
 	<-done
 	log.Print("Server Stopping..")
 
-	err := dbConn.Close()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := httpSrv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("error while stopping http server: %s", err.Error())
+	}
+
+	err = dbConn.Close()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -4,6 +4,8 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // database .env for app
@@ -17,13 +19,88 @@ type DatabaseConfig struct {
 	OTHER_P map[string]string
 }
 
+// jwt .env for app
+type TokenConfig struct {
+	JWT_PRIVATE_KEY string
+	JWT_KEYS_DIR    string
+	JWT_ACTIVE_KID  string
+	JWT_KEYS_RELOAD time.Duration
+	JWT_ISSUER      string
+	JWT_ACCESS_TTL  time.Duration
+	JWT_REFRESH_TTL time.Duration
+}
+
+// account policy .env for app
+type AuthConfig struct {
+	AUTH_REQUIRE_VERIFIED_EMAIL bool
+	EMAIL_VERIFICATION_TTL      time.Duration
+	PASSWORD_RESET_TTL          time.Duration
+	AUTH_LOCKOUT_THRESHOLD      uint32
+	AUTH_LOCKOUT_DURATION       time.Duration
+	AUTH_LOCKOUT_MAX_DURATION   time.Duration
+	AUTH_DELETED_EMAIL_POLICY   string
+	AUTH_RESTORE_WINDOW         time.Duration
+}
+
+// AUTH_DELETED_EMAIL_POLICY values: email of deleted account stays taken or can be registered again
+const (
+	DeletedEmailReject = "reject"
+	DeletedEmailReuse  = "reuse"
+)
+
+// multi-factor authentication .env for app
+type MFAConfig struct {
+	MFA_ENCRYPTION_KEY string
+	MFA_ISSUER         string
+	MFA_CHALLENGE_TTL  time.Duration
+	MFA_REQUIRED_ROLES []string
+	MFA_RECOVERY_CODES uint32
+}
+
+// account emails .env for app
+type NotifyConfig struct {
+	NOTIFY_DRIVER        string
+	NOTIFY_FILE          string
+	NOTIFY_FROM          string
+	NOTIFY_BASE_URL      string
+	NOTIFY_TEMPLATES_DIR string
+	SMTP_HOST            string
+	SMTP_PORT            string
+	SMTP_USER            string
+	SMTP_PASS            string
+}
+
+// purge of deleted users .env for app
+type RetentionConfig struct {
+	RETENTION_PERIOD   time.Duration
+	RETENTION_INTERVAL time.Duration
+	RETENTION_DRY_RUN  bool
+}
+
+// rate limits .env for app
+type RateLimitConfig struct {
+	RATE_LIMIT_RULES string
+}
+
+// limits of login, enumeration and email sending endpoints, see ratelimit.ParseRules
+const defaultRateLimitRules = "AuthUser:peer:20/1m,AuthUser:email:5/1m,VerifyMFA:peer:20/1m," +
+	"GetUserByEmail:peer:30/1m,RequestPasswordReset:peer:10/1m,RequestPasswordReset:email:3/1h," +
+	"SendVerificationEmail:peer:10/1m,SendVerificationEmail:email:3/1h"
+
 // accumulate env
 type Config struct {
 	DBConfig          DatabaseConfig
+	TokenConfig       TokenConfig
+	AuthConfig        AuthConfig
+	NotifyConfig      NotifyConfig
+	MFAConfig         MFAConfig
+	RateLimitConfig   RateLimitConfig
+	RetentionConfig   RetentionConfig
 	CurrentAppVersion string
 	Debug_mode        bool
 	Hostname          string
 	TCPPort           string
+	HTTPPort          string
 }
 
 // singleton instance
@@ -47,10 +124,56 @@ func GetConfig() *Config {
 				DB_PASS: getEnv("DB_PASS"),
 				OTHER_P: other_p,
 			},
+			TokenConfig: TokenConfig{
+				JWT_PRIVATE_KEY: getEnv("JWT_PRIVATE_KEY"),
+				JWT_KEYS_DIR:    getEnv("JWT_KEYS_DIR"),
+				JWT_ACTIVE_KID:  getEnv("JWT_ACTIVE_KID"),
+				JWT_KEYS_RELOAD: getDurationEnv("JWT_KEYS_RELOAD", time.Minute),
+				JWT_ISSUER:      getEnv("JWT_ISSUER"),
+				JWT_ACCESS_TTL:  getDurationEnv("JWT_ACCESS_TTL", 15*time.Minute),
+				JWT_REFRESH_TTL: getDurationEnv("JWT_REFRESH_TTL", 30*24*time.Hour),
+			},
+			AuthConfig: AuthConfig{
+				AUTH_REQUIRE_VERIFIED_EMAIL: getBoolEnvDefault("AUTH_REQUIRE_VERIFIED_EMAIL", false),
+				EMAIL_VERIFICATION_TTL:      getDurationEnv("EMAIL_VERIFICATION_TTL", 24*time.Hour),
+				PASSWORD_RESET_TTL:          getDurationEnv("PASSWORD_RESET_TTL", time.Hour),
+				AUTH_LOCKOUT_THRESHOLD:      getUIntEnvDefault("AUTH_LOCKOUT_THRESHOLD", 5),
+				AUTH_LOCKOUT_DURATION:       getDurationEnv("AUTH_LOCKOUT_DURATION", 15*time.Minute),
+				AUTH_LOCKOUT_MAX_DURATION:   getDurationEnv("AUTH_LOCKOUT_MAX_DURATION", 24*time.Hour),
+				AUTH_DELETED_EMAIL_POLICY:   getEnumEnv("AUTH_DELETED_EMAIL_POLICY", DeletedEmailReject, DeletedEmailReuse),
+				AUTH_RESTORE_WINDOW:         getDurationEnv("AUTH_RESTORE_WINDOW", 30*24*time.Hour),
+			},
+			NotifyConfig: NotifyConfig{
+				NOTIFY_DRIVER:        getEnv("NOTIFY_DRIVER"),
+				NOTIFY_FILE:          getEnv("NOTIFY_FILE"),
+				NOTIFY_FROM:          getEnv("NOTIFY_FROM"),
+				NOTIFY_BASE_URL:      getEnv("NOTIFY_BASE_URL"),
+				NOTIFY_TEMPLATES_DIR: getEnv("NOTIFY_TEMPLATES_DIR"),
+				SMTP_HOST:            getEnv("SMTP_HOST"),
+				SMTP_PORT:            getEnvDefault("SMTP_PORT", "587"),
+				SMTP_USER:            getEnv("SMTP_USER"),
+				SMTP_PASS:            getEnv("SMTP_PASS"),
+			},
+			MFAConfig: MFAConfig{
+				MFA_ENCRYPTION_KEY: getEnv("MFA_ENCRYPTION_KEY"),
+				MFA_ISSUER:         getEnvDefault("MFA_ISSUER", "UserAgent"),
+				MFA_CHALLENGE_TTL:  getDurationEnv("MFA_CHALLENGE_TTL", 5*time.Minute),
+				MFA_REQUIRED_ROLES: getListEnv("MFA_REQUIRED_ROLES"),
+				MFA_RECOVERY_CODES: getUIntEnvDefault("MFA_RECOVERY_CODES", 10),
+			},
+			RetentionConfig: RetentionConfig{
+				RETENTION_PERIOD:   getDurationEnv("RETENTION_PERIOD", 0),
+				RETENTION_INTERVAL: getDurationEnv("RETENTION_INTERVAL", time.Hour),
+				RETENTION_DRY_RUN:  getBoolEnvDefault("RETENTION_DRY_RUN", false),
+			},
+			RateLimitConfig: RateLimitConfig{
+				RATE_LIMIT_RULES: getEnvDefault("RATE_LIMIT_RULES", defaultRateLimitRules),
+			},
 			CurrentAppVersion: getEnv("APP_VERSION"),
 			Debug_mode:        getBoolEnv("DEBUG_MODE"),
 			Hostname:          getEnv("HOSTNAME"),
 			TCPPort:           getEnv("PORT"),
+			HTTPPort:          getEnv("HTTP_PORT"),
 		}
 	}
 	return config
@@ -60,6 +183,37 @@ func getEnv(key string) string {
 	return os.Getenv(key)
 }
 
+// returns def if the variable is unset
+func getEnvDefault(key, def string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return def
+}
+
+// one of allowed values, the first one is default
+func getEnumEnv(key string, allowed ...string) string {
+	val := getEnvDefault(key, allowed[0])
+	for _, item := range allowed {
+		if val == item {
+			return val
+		}
+	}
+	log.Fatalf("error while parse value: %s must be one of %s", key, strings.Join(allowed, ", "))
+	return ""
+}
+
+// comma separated values; empty items are skipped
+func getListEnv(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 func getFloatEnv(key string) float32 {
 	val, err := strconv.ParseFloat(os.Getenv(key), 32)
 	if err != nil {
@@ -84,6 +238,14 @@ func getBoolEnv(key string) bool {
 	return val
 }
 
+// returns def if the variable is unset
+func getBoolEnvDefault(key string, def bool) bool {
+	if os.Getenv(key) == "" {
+		return def
+	}
+	return getBoolEnv(key)
+}
+
 func getUIntEnv(key string) uint32 {
 	val, err := strconv.ParseUint(os.Getenv(key), 10, 32)
 	if err != nil {
@@ -91,3 +253,24 @@ func getUIntEnv(key string) uint32 {
 	}
 	return uint32(val)
 }
+
+// returns def if the variable is unset
+func getUIntEnvDefault(key string, def uint32) uint32 {
+	if os.Getenv(key) == "" {
+		return def
+	}
+	return getUIntEnv(key)
+}
+
+// returns def if the variable is unset
+func getDurationEnv(key string, def time.Duration) time.Duration {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	val, err := time.ParseDuration(raw)
+	if err != nil {
+		log.Fatalf("error while parse value: %s", err.Error())
+	}
+	return val
+}
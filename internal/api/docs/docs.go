@@ -0,0 +1,64 @@
+package docs
+
+import (
+	"embed"
+	"log"
+	"net/http"
+
+	swagger "github.com/golang-unitied-school/useragent/api/v1/proto"
+)
+
+const Prefix = "/docs/"
+
+// explorer page with its script and styles; no external assets, so docs work offline
+//
+//go:embed index.html explorer.js explorer.css
+var assets embed.FS
+
+type file struct {
+	name        string
+	contentType string
+}
+
+// served files by path under Prefix; empty path is the explorer page
+var files = map[string]file{
+	"":             {"index.html", "text/html; charset=utf-8"},
+	"explorer.js":  {"explorer.js", "text/javascript; charset=utf-8"},
+	"explorer.css": {"explorer.css", "text/css; charset=utf-8"},
+	"swagger.json": {"", "application/json"},
+}
+
+// serve API explorer page at /docs/ and OpenAPI document at /docs/swagger.json
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if len(r.URL.Path) < len(Prefix) || r.URL.Path[:len(Prefix)] != Prefix {
+			http.NotFound(w, r)
+			return
+		}
+
+		f, ok := files[r.URL.Path[len(Prefix):]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		content := swagger.Swagger
+		if f.name != "" {
+			var err error
+			if content, err = assets.ReadFile(f.name); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", f.contentType)
+		if _, err := w.Write(content); err != nil {
+			log.Printf("error while writing docs: %s", err.Error())
+		}
+	})
+}
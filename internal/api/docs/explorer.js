@@ -0,0 +1,140 @@
+// API explorer for swagger.json (OpenAPI 2.0): lists operations and sends requests
+// to the REST gateway on the same host; no external scripts, so it works offline
+(function () {
+  "use strict";
+
+  var tokenInput = document.getElementById("token");
+  tokenInput.value = sessionStorage.getItem("useragent-token") || "";
+  tokenInput.addEventListener("change", function () {
+    sessionStorage.setItem("useragent-token", tokenInput.value.trim());
+  });
+
+  function el(tag, attrs, children) {
+    var node = document.createElement(tag);
+    Object.keys(attrs || {}).forEach(function (key) {
+      if (key === "text") {
+        node.textContent = attrs[key];
+      } else {
+        node.setAttribute(key, attrs[key]);
+      }
+    });
+    (children || []).forEach(function (child) { node.appendChild(child); });
+    return node;
+  }
+
+  // skeleton JSON value for schema, used as body template
+  function example(spec, schema, depth) {
+    if (!schema || depth > 5) {
+      return null;
+    }
+    if (schema.$ref) {
+      return example(spec, spec.definitions[schema.$ref.replace("#/definitions/", "")], depth + 1);
+    }
+    switch (schema.type) {
+      case "object":
+        var value = {};
+        Object.keys(schema.properties || {}).forEach(function (name) {
+          value[name] = example(spec, schema.properties[name], depth + 1);
+        });
+        return value;
+      case "array":
+        return [];
+      case "boolean":
+        return false;
+      case "integer":
+      case "number":
+        return 0;
+      default:
+        return "";
+    }
+  }
+
+  function send(method, path, params, form, output) {
+    var query = new URLSearchParams();
+    var body = null;
+
+    params.forEach(function (param) {
+      var value = form.elements[param.name].value;
+      if (param.in === "body") {
+        body = value.trim() === "" ? null : value;
+      } else if (param.in === "path") {
+        path = path.replace("{" + param.name + "}", encodeURIComponent(value));
+      } else if (param.in === "query" && value !== "") {
+        query.append(param.name, value);
+      }
+    });
+
+    var headers = { "Accept": "application/json" };
+    if (body !== null) {
+      headers["Content-Type"] = "application/json";
+    }
+    if (tokenInput.value.trim() !== "") {
+      headers["Authorization"] = "Bearer " + tokenInput.value.trim();
+    }
+
+    var url = path + (query.toString() ? "?" + query.toString() : "");
+    output.textContent = method.toUpperCase() + " " + url + "\n...";
+
+    fetch(url, { method: method.toUpperCase(), headers: headers, body: body })
+      .then(function (resp) {
+        return resp.text().then(function (text) {
+          try {
+            text = JSON.stringify(JSON.parse(text), null, 2);
+          } catch (e) {
+            // not JSON, show as is
+          }
+          output.textContent = method.toUpperCase() + " " + url + "\n" + resp.status + " " + resp.statusText + "\n\n" + text;
+        });
+      })
+      .catch(function (err) {
+        output.textContent = method.toUpperCase() + " " + url + "\n" + err;
+      });
+  }
+
+  function operation(spec, path, method, op) {
+    var params = op.parameters || [];
+    var form = el("form");
+    var output = el("pre", { text: "" });
+
+    params.forEach(function (param) {
+      var field;
+      if (param.in === "body") {
+        field = el("textarea", { name: param.name });
+        field.value = JSON.stringify(example(spec, param.schema, 0), null, 2);
+      } else {
+        field = el("input", { name: param.name, type: "text", placeholder: param.type || "" });
+      }
+      form.appendChild(el("label", {}, [el("span", { text: param.name + " (" + param.in + ")" }), field]));
+    });
+
+    form.appendChild(el("button", { type: "submit", text: "Try it out" }));
+    form.addEventListener("submit", function (event) {
+      event.preventDefault();
+      send(method, path, params, form, output);
+    });
+
+    var summary = el("summary", {}, [
+      el("span", { "class": "method", text: method }),
+      el("span", { "class": "path", text: path }),
+      el("span", { "class": "summary", text: op.summary || op.operationId || "" }),
+    ]);
+
+    return el("details", {}, [summary, form, output]);
+  }
+
+  fetch("swagger.json")
+    .then(function (resp) { return resp.json(); })
+    .then(function (spec) {
+      var root = document.getElementById("operations");
+      document.getElementById("title").textContent = (spec.info && spec.info.title) || "UserAgent API";
+
+      Object.keys(spec.paths).sort().forEach(function (path) {
+        Object.keys(spec.paths[path]).forEach(function (method) {
+          root.appendChild(operation(spec, path, method, spec.paths[path][method]));
+        });
+      });
+    })
+    .catch(function (err) {
+      document.getElementById("operations").textContent = "error while loading swagger.json: " + err;
+    });
+})();
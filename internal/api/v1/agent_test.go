@@ -0,0 +1,133 @@
+package v1
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-unitied-school/useragent/config"
+	"github.com/golang-unitied-school/useragent/internal/models"
+	"github.com/golang-unitied-school/useragent/internal/pkg/mfa"
+	"github.com/golang-unitied-school/useragent/internal/pkg/notify"
+	"github.com/golang-unitied-school/useragent/internal/pkg/tokens"
+	"github.com/golang-unitied-school/useragent/internal/repositories/users"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const testPassword = "Passw0rd!"
+
+// agent backed by Memory storage; emails go to a file in test dir
+func newTestAgent(t *testing.T) *UserAgent {
+	t.Helper()
+
+	store := new(users.Memory)
+	store.Init("")
+
+	manager, err := tokens.NewManager(config.TokenConfig{
+		JWT_ACCESS_TTL:  15 * time.Minute,
+		JWT_REFRESH_TTL: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := make([]byte, 32)
+	if _, err = rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	mfaManager, err := mfa.NewManager(config.MFAConfig{
+		MFA_ENCRYPTION_KEY: base64.StdEncoding.EncodeToString(key),
+		MFA_ISSUER:         "useragent",
+		MFA_CHALLENGE_TTL:  5 * time.Minute,
+		MFA_RECOVERY_CODES: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mailer, err := notify.New(config.NotifyConfig{
+		NOTIFY_DRIVER: "file",
+		NOTIFY_FILE:   filepath.Join(t.TempDir(), "mail.txt"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { mailer.Close() })
+
+	return &UserAgent{
+		DBConn:    store,
+		TokenConn: store,
+		Tokens:    manager,
+		Mailer:    mailer,
+		MFA:       mfaManager,
+		Auth: config.AuthConfig{
+			AUTH_LOCKOUT_THRESHOLD:    3,
+			AUTH_LOCKOUT_DURATION:     time.Minute,
+			AUTH_LOCKOUT_MAX_DURATION: time.Hour,
+			AUTH_DELETED_EMAIL_POLICY: config.DeletedEmailReject,
+			AUTH_RESTORE_WINDOW:       time.Hour,
+		},
+	}
+}
+
+func createUser(t *testing.T, agent *UserAgent, email string) string {
+	t.Helper()
+
+	resp, err := agent.CreateUser(context.Background(), &CreateUserRequest{
+		Name:     "Ivan",
+		Surname:  "Petrov",
+		Email:    email,
+		Password: testPassword,
+	})
+	if err != nil {
+		t.Fatalf("create %s: %v", email, err)
+	}
+
+	return resp.GetUserId()
+}
+
+func createAdmin(t *testing.T, agent *UserAgent, email string) string {
+	t.Helper()
+
+	resp, err := agent.CreateUser(context.Background(), &CreateUserRequest{
+		Name:     "Anna",
+		Surname:  "Admin",
+		Email:    email,
+		Password: testPassword,
+		Role:     models.RoleAdmin,
+	})
+	if err != nil {
+		t.Fatalf("create %s: %v", email, err)
+	}
+
+	return resp.GetUserId()
+}
+
+func login(t *testing.T, agent *UserAgent, email string) *AuthUserResponse {
+	t.Helper()
+
+	resp, err := agent.AuthUser(context.Background(), &AuthUserRequest{Email: email, Password: testPassword})
+	if err != nil {
+		t.Fatalf("login %s: %v", email, err)
+	}
+
+	return resp
+}
+
+// context of call with bearer access token
+func withBearer(accessToken string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+accessToken))
+}
+
+func assertCode(t *testing.T, err error, want codes.Code) {
+	t.Helper()
+
+	if got := status.Code(err); got != want {
+		t.Fatalf("got code %s (%v), want %s", got, err, want)
+	}
+}
@@ -0,0 +1,73 @@
+package v1
+
+import (
+	"context"
+	"log"
+
+	"github.com/golang-unitied-school/useragent/internal/models"
+	global "github.com/golang-unitied-school/useragent/internal/pkg/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// irreversibly remove user with tokens and recovery codes; caller must be the account owner
+// or admin and is stored in the erasure report, response has no personal data.
+// Admin erases only soft deleted accounts; owner's access token works only while the account
+// is active, so the owner's own account is deleted and erased in one step
+func (agent *UserAgent) EraseUser(ctx context.Context, req *EraseUserRequest) (*EraseUserResponse, error) {
+
+	if !global.IsValidUUID(req.GetUserId()) {
+		return nil, status.Error(codes.InvalidArgument, global.ErrorInvalidFormat.Error())
+	}
+
+	by, err := agent.authorize(ctx, req.GetUserId(), models.RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	as := models.ErasedAsAdmin
+	if by.id == req.GetUserId() {
+		as = models.ErasedAsOwner
+	}
+
+	var report models.ErasureReport
+	err = agent.inTx(ctx, func(tx *UserAgent) error {
+		if as == models.ErasedAsOwner {
+			if err := tx.DBConn.Delete(ctx, req.GetUserId(), by.id); err != nil {
+				return internalError(err)
+			}
+		}
+
+		var err error
+		report, err = tx.DBConn.Erase(ctx, req.GetUserId(), by.id, as)
+		if err == global.ErrorUserNotFound {
+			return status.Error(codes.NotFound, global.ErrorUserNotFound.Error())
+		}
+
+		if err == global.ErrorUserNotDeleted {
+			return status.Error(codes.FailedPrecondition, global.ErrorUserNotDeleted.Error())
+		}
+
+		if err != nil {
+			return internalError(err)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("user %s erased by %q as %s", report.UserId, by.id, as)
+
+	return &EraseUserResponse{
+		UserId:        report.UserId.String(),
+		ErasedAt:      timestamppb.New(report.ErasedAt),
+		RefreshTokens: report.RefreshTokens,
+		RevokedTokens: report.RevokedTokens,
+		ActionTokens:  report.ActionTokens,
+		RecoveryCodes: report.RecoveryCodes,
+	}, nil
+}
@@ -0,0 +1,110 @@
+package v1
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang-unitied-school/useragent/internal/models"
+	global "github.com/golang-unitied-school/useragent/internal/pkg/utils"
+	"google.golang.org/grpc/codes"
+)
+
+func TestEraseUser(t *testing.T) {
+	agent := newTestAgent(t)
+	ctx := context.Background()
+	adminId := createAdmin(t, agent, "admin@example.com")
+	admin := login(t, agent, "admin@example.com")
+	id := createUser(t, agent, "ivan@example.com")
+	login(t, agent, "ivan@example.com")
+
+	_, err := agent.EraseUser(ctx, &EraseUserRequest{UserId: id})
+	assertCode(t, err, codes.Unauthenticated)
+
+	// active account must be deleted first
+	_, err = agent.EraseUser(withBearer(admin.GetAccessToken()), &EraseUserRequest{UserId: id})
+	assertCode(t, err, codes.FailedPrecondition)
+
+	if _, err = agent.DeleteUser(withBearer(admin.GetAccessToken()), &DeleteUserRequest{UserId: id}); err != nil {
+		t.Fatal(err)
+	}
+
+	erased, err := agent.EraseUser(withBearer(admin.GetAccessToken()), &EraseUserRequest{UserId: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if erased.GetRefreshTokens() != 1 || erased.GetActionTokens() != 1 {
+		t.Fatalf("unexpected report: %v", erased)
+	}
+
+	_, err = agent.GetUserById(ctx, &GetUserRequest{UserId: id, IncludeDeleted: true})
+	assertCode(t, err, codes.NotFound)
+
+	_, err = agent.EraseUser(withBearer(admin.GetAccessToken()), &EraseUserRequest{UserId: id})
+	assertCode(t, err, codes.NotFound)
+
+	report, err := agent.DBConn.GetErasureReport(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ErasedBy != adminId || report.ErasedAs != models.ErasedAsAdmin || report.DeletedAt == nil || report.RefreshTokens != erased.GetRefreshTokens() {
+		t.Fatalf("unexpected stored report: %+v", report)
+	}
+
+	if _, err = agent.DBConn.GetErasureReport(ctx, adminId); err != global.ErrorUserNotFound {
+		t.Fatalf("got %v for user who was not erased", err)
+	}
+}
+
+func TestEraseUserRequiresOwnerOrAdmin(t *testing.T) {
+	agent := newTestAgent(t)
+	ctx := context.Background()
+	id := createUser(t, agent, "ivan@example.com")
+	createUser(t, agent, "petr@example.com")
+	ivan := login(t, agent, "ivan@example.com")
+	petr := login(t, agent, "petr@example.com")
+
+	_, err := agent.EraseUser(withBearer(petr.GetAccessToken()), &EraseUserRequest{UserId: id})
+	assertCode(t, err, codes.PermissionDenied)
+
+	// owner erases own active account in one step
+	erased, err := agent.EraseUser(withBearer(ivan.GetAccessToken()), &EraseUserRequest{UserId: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if erased.GetRefreshTokens() != 1 {
+		t.Fatalf("unexpected report: %v", erased)
+	}
+
+	_, err = agent.RefreshToken(ctx, &RefreshTokenRequest{RefreshToken: ivan.GetRefreshToken()})
+	assertCode(t, err, codes.Unauthenticated)
+
+	report, err := agent.DBConn.GetErasureReport(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ErasedBy != id || report.ErasedAs != models.ErasedAsOwner {
+		t.Fatalf("unexpected stored report: %+v", report)
+	}
+}
+
+func TestEraseByRetentionActor(t *testing.T) {
+	agent := newTestAgent(t)
+	ctx := context.Background()
+	id := createUser(t, agent, "ivan@example.com")
+
+	if _, err := agent.DBConn.Erase(ctx, id, models.RetentionActor, models.RetentionActor); err != global.ErrorUserNotDeleted {
+		t.Fatalf("active user erased: %v", err)
+	}
+
+	if _, err := agent.DeleteUser(ctx, &DeleteUserRequest{UserId: id}); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := agent.DBConn.Erase(ctx, id, models.RetentionActor, models.RetentionActor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ErasedBy != models.RetentionActor || report.ErasedAs != models.RetentionActor {
+		t.Fatalf("unexpected actor %q as %q", report.ErasedBy, report.ErasedAs)
+	}
+}
@@ -0,0 +1,227 @@
+package v1
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/golang-unitied-school/useragent/internal/models"
+	global "github.com/golang-unitied-school/useragent/internal/pkg/utils"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	exportFormat    = "useragent-export/v1"
+	exportChunkSize = 32 << 10
+)
+
+// notes on archive sections that are always empty
+const (
+	exportAuditNote    = "service keeps no audit log; who deleted the account is in profile.deleted_by, who revoked tokens is in revoked_by of sessions and revoked_access_tokens"
+	exportConsentsNote = "service stores no consents"
+)
+
+// JSON archive of personal data export; password hash, TOTP secret and
+// token hashes are credentials, not personal data, and are left out.
+// Audit and Consents are requested by GDPR Art. 15 but not stored, so they
+// are always empty and Notes tell why
+type exportArchive struct {
+	Format              string               `json:"format"`
+	ExportedAt          time.Time            `json:"exported_at"`
+	Profile             exportProfile        `json:"profile"`
+	Security            exportSecurity       `json:"security"`
+	Logins              []exportLogin        `json:"logins"`
+	Sessions            []exportSession      `json:"sessions"`
+	RevokedAccessTokens []exportRevokedToken `json:"revoked_access_tokens"`
+	ActionTokens        []exportActionToken  `json:"action_tokens"`
+	Audit               []any                `json:"audit"`
+	Consents            []any                `json:"consents"`
+	Notes               map[string]string    `json:"notes"`
+}
+
+type exportProfile struct {
+	UserId        uuid.UUID  `json:"user_id"`
+	Name          string     `json:"name"`
+	Surname       string     `json:"surname"`
+	Email         string     `json:"email"`
+	EmailVerified bool       `json:"email_verified"`
+	Role          string     `json:"role"`
+	CreatedAt     time.Time  `json:"created_at"`
+	Deleted       bool       `json:"deleted"`
+	DeletedAt     *time.Time `json:"deleted_at"`
+	DeletedBy     string     `json:"deleted_by"`
+}
+
+type exportSecurity struct {
+	MfaEnabled    bool                 `json:"mfa_enabled"`
+	MfaEnrolled   bool                 `json:"mfa_enrolled"`
+	RecoveryCodes []exportRecoveryCode `json:"recovery_codes"`
+	FailedLogins  int32                `json:"failed_logins"`
+	Lockouts      int32                `json:"lockouts"`
+	LockedUntil   *time.Time           `json:"locked_until"`
+}
+
+type exportRecoveryCode struct {
+	CreatedAt time.Time  `json:"created_at"`
+	UsedAt    *time.Time `json:"used_at"`
+}
+
+// start of session family, i.e. successful login
+type exportLogin struct {
+	SessionId  uuid.UUID `json:"session_id"`
+	LoggedInAt time.Time `json:"logged_in_at"`
+}
+
+// refresh token; tokens of one login share session_id
+type exportSession struct {
+	Id        uuid.UUID  `json:"id"`
+	SessionId uuid.UUID  `json:"session_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RotatedAt *time.Time `json:"rotated_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	RevokedBy string     `json:"revoked_by"`
+}
+
+type exportRevokedToken struct {
+	Jti       string    `json:"jti"`
+	ExpiresAt time.Time `json:"expires_at"`
+	RevokedAt time.Time `json:"revoked_at"`
+	RevokedBy string    `json:"revoked_by"`
+}
+
+type exportActionToken struct {
+	Purpose   string     `json:"purpose"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+}
+
+func newExportArchive(data models.UserData) exportArchive {
+	user := data.User
+	archive := exportArchive{
+		Format:     exportFormat,
+		ExportedAt: time.Now().UTC(),
+		Profile: exportProfile{
+			UserId:        user.Id,
+			Name:          user.Name,
+			Surname:       user.Surname,
+			Email:         user.Email,
+			EmailVerified: user.EmailVerified,
+			Role:          user.Role,
+			CreatedAt:     user.CreatedAt,
+			Deleted:       user.IsDeleted != 0,
+			DeletedAt:     user.DeletedAt,
+			DeletedBy:     user.DeletedBy,
+		},
+		Security: exportSecurity{
+			MfaEnabled:    user.MfaEnabled,
+			MfaEnrolled:   user.TotpSecret != "",
+			RecoveryCodes: make([]exportRecoveryCode, 0, len(data.RecoveryCodes)),
+			FailedLogins:  user.FailedLogins,
+			Lockouts:      user.Lockouts,
+			LockedUntil:   user.LockedUntil,
+		},
+		Logins:              []exportLogin{},
+		Sessions:            make([]exportSession, 0, len(data.RefreshTokens)),
+		RevokedAccessTokens: make([]exportRevokedToken, 0, len(data.RevokedTokens)),
+		ActionTokens:        make([]exportActionToken, 0, len(data.ActionTokens)),
+		Audit:               []any{},
+		Consents:            []any{},
+		Notes: map[string]string{
+			"audit":    exportAuditNote,
+			"consents": exportConsentsNote,
+		},
+	}
+
+	for _, code := range data.RecoveryCodes {
+		archive.Security.RecoveryCodes = append(archive.Security.RecoveryCodes, exportRecoveryCode{CreatedAt: code.CreatedAt, UsedAt: code.UsedAt})
+	}
+
+	logins := make(map[uuid.UUID]time.Time)
+	for _, token := range data.RefreshTokens {
+		archive.Sessions = append(archive.Sessions, exportSession{
+			Id:        token.Id,
+			SessionId: token.FamilyId,
+			CreatedAt: token.CreatedAt,
+			ExpiresAt: token.ExpiresAt,
+			RotatedAt: token.RotatedAt,
+			RevokedAt: token.RevokedAt,
+			RevokedBy: token.RevokedBy,
+		})
+
+		if at, ok := logins[token.FamilyId]; !ok || token.CreatedAt.Before(at) {
+			logins[token.FamilyId] = token.CreatedAt
+		}
+	}
+
+	for session, at := range logins {
+		archive.Logins = append(archive.Logins, exportLogin{SessionId: session, LoggedInAt: at})
+	}
+	sort.Slice(archive.Logins, func(i, j int) bool {
+		return archive.Logins[i].LoggedInAt.Before(archive.Logins[j].LoggedInAt)
+	})
+
+	for _, token := range data.RevokedTokens {
+		archive.RevokedAccessTokens = append(archive.RevokedAccessTokens, exportRevokedToken{
+			Jti:       token.Jti,
+			ExpiresAt: token.ExpiresAt,
+			RevokedAt: token.RevokedAt,
+			RevokedBy: token.RevokedBy,
+		})
+	}
+
+	for _, token := range data.ActionTokens {
+		archive.ActionTokens = append(archive.ActionTokens, exportActionToken{
+			Purpose:   token.Purpose,
+			CreatedAt: token.CreatedAt,
+			ExpiresAt: token.ExpiresAt,
+			UsedAt:    token.UsedAt,
+		})
+	}
+
+	return archive
+}
+
+// stream JSON archive with all data stored about user, also deleted one (GDPR Art. 15);
+// allowed to the account owner and admin, REST download passes Authorization header through
+func (agent *UserAgent) ExportUserData(req *ExportUserDataRequest, stream UserAgent_ExportUserDataServer) error {
+
+	if !global.IsValidUUID(req.GetUserId()) {
+		return status.Error(codes.InvalidArgument, global.ErrorInvalidFormat.Error())
+	}
+
+	if _, err := agent.authorize(stream.Context(), req.GetUserId(), models.RoleAdmin); err != nil {
+		return err
+	}
+
+	data, err := agent.DBConn.GetUserData(stream.Context(), req.GetUserId())
+	if err == global.ErrorUserNotFound {
+		return status.Error(codes.NotFound, global.ErrorUserNotFound.Error())
+	}
+
+	if err != nil {
+		return internalError(err)
+	}
+
+	archive, err := json.MarshalIndent(newExportArchive(data), "", "  ")
+	if err != nil {
+		return internalError(err)
+	}
+
+	for len(archive) > 0 {
+		n := exportChunkSize
+		if n > len(archive) {
+			n = len(archive)
+		}
+
+		if err = stream.Send(&ExportUserDataChunk{Data: archive[:n]}); err != nil {
+			return err
+		}
+		archive = archive[n:]
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestExportRequiresOwnerOrAdmin(t *testing.T) {
+	agent := newTestAgent(t)
+	ctx := context.Background()
+	id := createUser(t, agent, "ivan@example.com")
+	createUser(t, agent, "petr@example.com")
+	createAdmin(t, agent, "admin@example.com")
+	ivan := login(t, agent, "ivan@example.com")
+	petr := login(t, agent, "petr@example.com")
+	admin := login(t, agent, "admin@example.com")
+
+	srv := grpc.NewServer()
+	RegisterUserAgentServer(srv, agent)
+	t.Cleanup(srv.Stop)
+
+	conn, err := DialInProcess(ctx, srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	mux, err := NewGateway(ctx, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewExportHandler(mux, conn)
+
+	download := func(accessToken string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, ExportPath+id, nil)
+		if accessToken != "" {
+			req.Header.Set("Authorization", "Bearer "+accessToken)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, tc := range []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"anonymous", "", http.StatusUnauthorized},
+		{"other user", petr.GetAccessToken(), http.StatusForbidden},
+		{"owner", ivan.GetAccessToken(), http.StatusOK},
+		{"admin", admin.GetAccessToken(), http.StatusOK},
+	} {
+		w := download(tc.token)
+		if w.Code != tc.want {
+			t.Fatalf("%s: got HTTP %d (%s), want %d", tc.name, w.Code, w.Body.String(), tc.want)
+		}
+
+		if w.Code == http.StatusOK {
+			var archive exportArchive
+			if err = json.Unmarshal(w.Body.Bytes(), &archive); err != nil {
+				t.Fatal(err)
+			}
+			if archive.Profile.UserId.String() != id {
+				t.Fatalf("%s: archive of another user: %s", tc.name, archive.Profile.UserId)
+			}
+		}
+	}
+
+	// gRPC stream reports the same errors
+	client := NewUserAgentClient(conn)
+	for _, tc := range []struct {
+		ctx  context.Context
+		want codes.Code
+	}{
+		{ctx, codes.Unauthenticated},
+		{metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+petr.GetAccessToken()), codes.PermissionDenied},
+	} {
+		stream, err := client.ExportUserData(tc.ctx, &ExportUserDataRequest{UserId: id})
+		if err == nil {
+			_, err = stream.Recv()
+		}
+		assertCode(t, err, tc.want)
+	}
+}
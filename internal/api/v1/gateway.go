@@ -0,0 +1,115 @@
+package v1
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/golang-unitied-school/useragent/internal/pkg/ratelimit"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const inProcessBufferSize = 1 << 20
+
+// prefix of REST download of ExportUserData archive, followed by user id
+const ExportPath = "/api/v1/export/"
+
+// REST gateway calling UserAgent through conn, so requests pass server interceptors;
+// gRPC errors are returned as JSON Status with matching HTTP status code
+func NewGateway(ctx context.Context, conn *grpc.ClientConn) (*runtime.ServeMux, error) {
+	mux := runtime.NewServeMux(
+		runtime.WithMarshalerOption(runtime.MIMEWildcard, &runtime.JSONPb{EmitDefaults: true}),
+		runtime.WithProtoErrorHandler(runtime.DefaultHTTPProtoErrorHandler),
+		runtime.WithOutgoingHeaderMatcher(outgoingHeader),
+	)
+
+	if err := RegisterUserAgentHandlerClient(ctx, mux, NewUserAgentClient(conn)); err != nil {
+		return nil, err
+	}
+
+	return mux, nil
+}
+
+// retry-after is sent as standard HTTP header, other metadata as Grpc-Metadata-*
+func outgoingHeader(key string) (string, bool) {
+	if key == ratelimit.RetryAfterKey {
+		return "Retry-After", true
+	}
+
+	return runtime.MetadataHeaderPrefix + key, true
+}
+
+// serve srv on in-memory listener and connect to it; used by REST gateway
+func DialInProcess(ctx context.Context, srv *grpc.Server) (*grpc.ClientConn, error) {
+	listener := bufconn.Listen(inProcessBufferSize)
+	go func() {
+		if err := srv.Serve(listener); err != nil {
+			log.Printf("error while serving in-process connection: %s", err.Error())
+		}
+	}()
+
+	return grpc.DialContext(ctx, "in-process",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+}
+
+// GET ExportPath{user_id}: ExportUserData archive as JSON file; generated gateway
+// would wrap every chunk of the stream into {"result": ...}
+func NewExportHandler(mux *runtime.ServeMux, conn *grpc.ClientConn) http.Handler {
+	client := NewUserAgentClient(conn)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, outbound := runtime.MarshalerForRequest(mux, req)
+		if req.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		ctx, err := runtime.AnnotateContext(req.Context(), mux, req)
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outbound, w, req, err)
+			return
+		}
+
+		userId := strings.TrimPrefix(req.URL.Path, ExportPath)
+		stream, err := client.ExportUserData(ctx, &ExportUserDataRequest{UserId: userId})
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outbound, w, req, err)
+			return
+		}
+
+		// errors before the first chunk still can be sent as HTTP status
+		chunk, err := stream.Recv()
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outbound, w, req, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="user-`+userId+`.json"`)
+		for {
+			if _, err = w.Write(chunk.GetData()); err != nil {
+				return
+			}
+
+			chunk, err = stream.Recv()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				log.Printf("error while streaming export of user %s: %s", userId, err.Error())
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,98 @@
+package v1
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang-unitied-school/useragent/internal/models"
+	global "github.com/golang-unitied-school/useragent/internal/pkg/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// inner func for checking lockout; expired lockout does not count
+func isLocked(user models.User) bool {
+	return user.LockedUntil != nil && time.Now().Before(*user.LockedUntil)
+}
+
+// inner func for length of the next lockout: AUTH_LOCKOUT_DURATION doubled
+// for every previous lockout, capped by AUTH_LOCKOUT_MAX_DURATION
+func (agent *UserAgent) lockoutWindow(lockouts int32) time.Duration {
+	window := agent.Auth.AUTH_LOCKOUT_DURATION
+	for i := int32(0); i < lockouts && window < agent.Auth.AUTH_LOCKOUT_MAX_DURATION; i++ {
+		window *= 2
+	}
+
+	if window > agent.Auth.AUTH_LOCKOUT_MAX_DURATION {
+		return agent.Auth.AUTH_LOCKOUT_MAX_DURATION
+	}
+
+	return window
+}
+
+// inner func for counting failed password or MFA code; counters are re-read under
+// row lock, so concurrent failures on different replicas are not lost
+func (agent *UserAgent) failLogin(ctx context.Context, userId string) error {
+	threshold := int32(agent.Auth.AUTH_LOCKOUT_THRESHOLD)
+	if threshold == 0 {
+		return nil
+	}
+
+	return agent.inTx(ctx, func(tx *UserAgent) error {
+		user, err := tx.DBConn.GetById(ctx, userId)
+		if err == global.ErrorUserNotFound {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		failed, lockouts := user.FailedLogins+1, user.Lockouts
+		var lockedUntil *time.Time
+		if failed >= threshold {
+			until := time.Now().Add(tx.lockoutWindow(lockouts))
+			failed, lockouts, lockedUntil = 0, lockouts+1, &until
+		}
+
+		return tx.DBConn.SetLockout(ctx, userId, failed, lockouts, lockedUntil)
+	})
+}
+
+// inner func for resetting counters after successful login
+func (agent *UserAgent) resetLockout(ctx context.Context, user models.User) error {
+	if user.FailedLogins == 0 && user.Lockouts == 0 && user.LockedUntil == nil {
+		return nil
+	}
+
+	return agent.DBConn.SetLockout(ctx, user.Id.String(), 0, 0, nil)
+}
+
+// admin: lift lockout and reset failed login counters
+func (agent *UserAgent) UnlockUser(ctx context.Context, req *UnlockUserRequest) (*emptypb.Empty, error) {
+
+	c, err := agent.requireCaller(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.role != models.RoleAdmin {
+		return nil, status.Error(codes.PermissionDenied, global.ErrorAdminRequired.Error())
+	}
+
+	if !global.IsValidUUID(req.GetUserId()) {
+		return nil, status.Error(codes.InvalidArgument, global.ErrorInvalidFormat.Error())
+	}
+
+	err = agent.DBConn.SetLockout(ctx, req.GetUserId(), 0, 0, nil)
+	if err == global.ErrorUserNotFound {
+		return nil, status.Error(codes.NotFound, global.ErrorUserNotFound.Error())
+	}
+
+	if err != nil {
+		return nil, internalError(err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
@@ -0,0 +1,77 @@
+package v1
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+)
+
+func TestLockoutAfterFailedLogins(t *testing.T) {
+	agent := newTestAgent(t)
+	ctx := context.Background()
+	id := createUser(t, agent, "ivan@example.com")
+	createAdmin(t, agent, "admin@example.com")
+	admin := login(t, agent, "admin@example.com")
+
+	wrong := &AuthUserRequest{Email: "ivan@example.com", Password: "Wr0ng-pass"}
+	for i := uint32(0); i < agent.Auth.AUTH_LOCKOUT_THRESHOLD; i++ {
+		_, err := agent.AuthUser(ctx, wrong)
+		assertCode(t, err, codes.Unauthenticated)
+	}
+
+	// correct password is not checked while account is locked, the answer is
+	// the same as for unknown email
+	_, err := agent.AuthUser(ctx, &AuthUserRequest{Email: "ivan@example.com", Password: testPassword})
+	assertCode(t, err, codes.Unauthenticated)
+
+	_, unknown := agent.AuthUser(ctx, &AuthUserRequest{Email: "nobody@example.com", Password: testPassword})
+	if err.Error() != unknown.Error() {
+		t.Fatalf("locked account is distinguishable: %v, unknown email: %v", err, unknown)
+	}
+
+	user, err := agent.GetUserById(ctx, &GetUserRequest{UserId: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.GetLockedUntil() == nil {
+		t.Fatal("locked_until is not set")
+	}
+
+	if _, err = agent.UnlockUser(withBearer(admin.GetAccessToken()), &UnlockUserRequest{UserId: id}); err != nil {
+		t.Fatal(err)
+	}
+	login(t, agent, "ivan@example.com")
+}
+
+func TestUnlockUserRequiresAdmin(t *testing.T) {
+	agent := newTestAgent(t)
+	id := createUser(t, agent, "ivan@example.com")
+	createUser(t, agent, "petr@example.com")
+	ivan := login(t, agent, "ivan@example.com")
+	petr := login(t, agent, "petr@example.com")
+
+	_, err := agent.UnlockUser(context.Background(), &UnlockUserRequest{UserId: id})
+	assertCode(t, err, codes.Unauthenticated)
+
+	_, err = agent.UnlockUser(withBearer(petr.GetAccessToken()), &UnlockUserRequest{UserId: id})
+	assertCode(t, err, codes.PermissionDenied)
+
+	// owner can not lift own lockout either
+	_, err = agent.UnlockUser(withBearer(ivan.GetAccessToken()), &UnlockUserRequest{UserId: id})
+	assertCode(t, err, codes.PermissionDenied)
+}
+
+func TestLockoutWindowDoubles(t *testing.T) {
+	agent := newTestAgent(t)
+
+	if got := agent.lockoutWindow(0); got != agent.Auth.AUTH_LOCKOUT_DURATION {
+		t.Fatalf("first lockout %s", got)
+	}
+	if got := agent.lockoutWindow(2); got != 4*agent.Auth.AUTH_LOCKOUT_DURATION {
+		t.Fatalf("third lockout %s", got)
+	}
+	if got := agent.lockoutWindow(20); got != agent.Auth.AUTH_LOCKOUT_MAX_DURATION {
+		t.Fatalf("lockout is not capped: %s", got)
+	}
+}
@@ -0,0 +1,345 @@
+package v1
+
+import (
+	"context"
+	"errors"
+
+	"github.com/golang-unitied-school/useragent/internal/models"
+	"github.com/golang-unitied-school/useragent/internal/pkg/mfa"
+	"github.com/golang-unitied-school/useragent/internal/pkg/tokens"
+	global "github.com/golang-unitied-school/useragent/internal/pkg/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// inner func for first login step of MFA account: password is correct, code is still needed
+func (agent *UserAgent) mfaChallenge(ctx context.Context, user models.User) (*AuthUserResponse, error) {
+	var token string
+	err := agent.inTx(ctx, func(tx *UserAgent) error {
+		challenge, err := tx.issueActionToken(ctx, user, models.ActionMFAChallenge, tx.MFA.ChallengeTTL())
+		if err != nil {
+			return internalError(err)
+		}
+
+		token = challenge.Token
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthUserResponse{MfaRequired: true, MfaToken: token}, nil
+}
+
+// inner func for checking TOTP code of user with enabled MFA; accepted code can not be used again
+func (agent *UserAgent) checkTOTP(ctx context.Context, user models.User, code string) error {
+	secret, err := agent.MFA.Decrypt(user.TotpSecret)
+	if err != nil {
+		return err
+	}
+
+	step, ok := agent.MFA.Validate(secret, code, user.MfaLastStep)
+	if !ok {
+		return global.ErrorInvalidMFACode
+	}
+
+	return agent.DBConn.UseMFAStep(ctx, user.Id.String(), step)
+}
+
+// inner func for checking second factor: TOTP code or one of recovery codes;
+// reports whether recovery code was used
+func (agent *UserAgent) checkSecondFactor(ctx context.Context, user models.User, code string) (bool, error) {
+	if mfa.IsTOTPCode(code) {
+		return false, agent.checkTOTP(ctx, user, code)
+	}
+
+	return true, agent.TokenConn.UseRecoveryCode(ctx, user.Id.String(), mfa.HashRecoveryCode(code))
+}
+
+// inner func for replacing recovery codes of user with a new set
+func (agent *UserAgent) issueRecoveryCodes(ctx context.Context, userId string) ([]string, error) {
+	codes, hashes, err := mfa.NewRecoveryCodes(agent.MFA.RecoveryCodes())
+	if err != nil {
+		return nil, err
+	}
+
+	if err = agent.TokenConn.ReplaceRecoveryCodes(ctx, userId, hashes); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// inner func for loading user for MFA management; allowed to the account owner and admin
+func (agent *UserAgent) mfaUser(ctx context.Context, userId string) (models.User, error) {
+	if !agent.MFA.Enabled() {
+		return models.User{}, status.Error(codes.FailedPrecondition, global.ErrorMFANotConfigured.Error())
+	}
+
+	if !global.IsValidUUID(userId) {
+		return models.User{}, status.Error(codes.InvalidArgument, global.ErrorInvalidFormat.Error())
+	}
+
+	if _, err := agent.authorize(ctx, userId, models.RoleAdmin); err != nil {
+		return models.User{}, err
+	}
+
+	user, err := agent.DBConn.GetById(ctx, userId)
+	if err == global.ErrorUserNotFound {
+		return user, status.Error(codes.NotFound, global.ErrorUserNotFound.Error())
+	}
+
+	if err != nil {
+		return user, internalError(err)
+	}
+
+	return user, nil
+}
+
+// second login step: exchange challenge and TOTP or recovery code for tokens;
+// challenge works once, so a wrong code requires new password check
+func (agent *UserAgent) VerifyMFA(ctx context.Context, req *VerifyMFARequest) (*AuthUserResponse, error) {
+
+	if req.GetMfaToken() == "" || req.GetCode() == "" {
+		return nil, status.Error(codes.InvalidArgument, global.ErrorEmptyToken.Error())
+	}
+
+	challenge, err := agent.TokenConn.UseActionToken(ctx, models.ActionMFAChallenge, tokens.HashToken(req.GetMfaToken()))
+	switch {
+	case errors.Is(err, global.ErrorTokenNotFound), errors.Is(err, global.ErrorTokenExpired):
+		return nil, status.Error(codes.Unauthenticated, global.ErrorTokenExpired.Error())
+	case err != nil:
+		return nil, internalError(err)
+	}
+
+	user, err := agent.DBConn.GetById(ctx, challenge.UserId.String())
+	if err == global.ErrorUserNotFound {
+		return nil, status.Error(codes.Unauthenticated, global.ErrorUnauthenticated.Error())
+	}
+
+	if err != nil {
+		return nil, internalError(err)
+	}
+
+	if !user.MfaEnabled {
+		return nil, status.Error(codes.Unauthenticated, global.ErrorMFANotEnabled.Error())
+	}
+
+	if isLocked(user) {
+		return nil, status.Error(codes.FailedPrecondition, global.ErrorAccountLocked.Error())
+	}
+
+	usedRecovery, err := agent.checkSecondFactor(ctx, user, req.GetCode())
+	if errors.Is(err, global.ErrorInvalidMFACode) {
+		if err = agent.failLogin(ctx, user.Id.String()); err != nil {
+			return nil, err
+		}
+		return nil, status.Error(codes.Unauthenticated, global.ErrorInvalidMFACode.Error())
+	}
+
+	if err != nil {
+		return nil, internalError(err)
+	}
+
+	response, err := agent.login(ctx, user)
+	if err != nil || !usedRecovery {
+		return response, err
+	}
+
+	if response.RecoveryCodesRemaining, err = agent.TokenConn.CountRecoveryCodes(ctx, user.Id.String()); err != nil {
+		return nil, internalError(err)
+	}
+
+	return response, nil
+}
+
+// start enrollment: generate secret; MFA is enabled only after ConfirmMFA
+func (agent *UserAgent) EnrollMFA(ctx context.Context, req *EnrollMFARequest) (*EnrollMFAResponse, error) {
+
+	var response EnrollMFAResponse
+	err := agent.inTx(ctx, func(tx *UserAgent) error {
+		user, err := tx.mfaUser(ctx, req.GetUserId())
+		if err != nil {
+			return err
+		}
+
+		if !global.ComparePasswords(req.GetPassword(), user.Password) {
+			return status.Error(codes.FailedPrecondition, global.ErrorPasswordNotMatched.Error())
+		}
+
+		if user.MfaEnabled {
+			return status.Error(codes.FailedPrecondition, global.ErrorMFAAlreadyEnabled.Error())
+		}
+
+		secret, uri, err := tx.MFA.Generate(user.Email)
+		if err != nil {
+			return internalError(err)
+		}
+
+		encrypted, err := tx.MFA.Encrypt(secret)
+		if err != nil {
+			return internalError(err)
+		}
+
+		if err = tx.DBConn.SetMFA(ctx, user.Id.String(), encrypted, false, 0); err != nil {
+			return internalError(err)
+		}
+
+		response = EnrollMFAResponse{Secret: secret, OtpauthUri: uri}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// finish enrollment with the first code from authenticator app; returns recovery codes
+func (agent *UserAgent) ConfirmMFA(ctx context.Context, req *ConfirmMFARequest) (*RecoveryCodesResponse, error) {
+
+	var recoveryCodes []string
+	err := agent.inTx(ctx, func(tx *UserAgent) error {
+		user, err := tx.mfaUser(ctx, req.GetUserId())
+		if err != nil {
+			return err
+		}
+
+		if user.MfaEnabled {
+			return status.Error(codes.FailedPrecondition, global.ErrorMFAAlreadyEnabled.Error())
+		}
+
+		if user.TotpSecret == "" {
+			return status.Error(codes.FailedPrecondition, global.ErrorMFANotEnrolled.Error())
+		}
+
+		secret, err := tx.MFA.Decrypt(user.TotpSecret)
+		if err != nil {
+			return internalError(err)
+		}
+
+		step, ok := tx.MFA.Validate(secret, req.GetCode(), 0)
+		if !ok {
+			return status.Error(codes.InvalidArgument, global.ErrorInvalidMFACode.Error())
+		}
+
+		if err = tx.DBConn.SetMFA(ctx, user.Id.String(), user.TotpSecret, true, step); err != nil {
+			return internalError(err)
+		}
+
+		if recoveryCodes, err = tx.issueRecoveryCodes(ctx, user.Id.String()); err != nil {
+			return internalError(err)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &RecoveryCodesResponse{RecoveryCodes: recoveryCodes}, nil
+}
+
+// turn MFA off; current TOTP or recovery code is required
+func (agent *UserAgent) DisableMFA(ctx context.Context, req *DisableMFARequest) (*emptypb.Empty, error) {
+
+	err := agent.inTx(ctx, func(tx *UserAgent) error {
+		user, err := tx.mfaUser(ctx, req.GetUserId())
+		if err != nil {
+			return err
+		}
+
+		if !user.MfaEnabled {
+			return status.Error(codes.FailedPrecondition, global.ErrorMFANotEnabled.Error())
+		}
+
+		_, err = tx.checkSecondFactor(ctx, user, req.GetCode())
+		if errors.Is(err, global.ErrorInvalidMFACode) {
+			return status.Error(codes.InvalidArgument, global.ErrorInvalidMFACode.Error())
+		}
+
+		if err != nil {
+			return internalError(err)
+		}
+
+		if err = tx.DBConn.SetMFA(ctx, user.Id.String(), "", false, 0); err != nil {
+			return internalError(err)
+		}
+
+		if err = tx.TokenConn.ReplaceRecoveryCodes(ctx, user.Id.String(), nil); err != nil {
+			return internalError(err)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// replace recovery codes with a new set; current TOTP code is required
+func (agent *UserAgent) RegenerateRecoveryCodes(ctx context.Context, req *RegenerateRecoveryCodesRequest) (*RecoveryCodesResponse, error) {
+
+	var recoveryCodes []string
+	err := agent.inTx(ctx, func(tx *UserAgent) error {
+		user, err := tx.mfaUser(ctx, req.GetUserId())
+		if err != nil {
+			return err
+		}
+
+		if !user.MfaEnabled {
+			return status.Error(codes.FailedPrecondition, global.ErrorMFANotEnabled.Error())
+		}
+
+		if !mfa.IsTOTPCode(req.GetCode()) {
+			return status.Error(codes.InvalidArgument, global.ErrorInvalidMFACode.Error())
+		}
+
+		err = tx.checkTOTP(ctx, user, req.GetCode())
+		if errors.Is(err, global.ErrorInvalidMFACode) {
+			return status.Error(codes.InvalidArgument, global.ErrorInvalidMFACode.Error())
+		}
+
+		if err != nil {
+			return internalError(err)
+		}
+
+		if recoveryCodes, err = tx.issueRecoveryCodes(ctx, user.Id.String()); err != nil {
+			return internalError(err)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &RecoveryCodesResponse{RecoveryCodes: recoveryCodes}, nil
+}
+
+func (agent *UserAgent) GetMFAStatus(ctx context.Context, req *GetMFAStatusRequest) (*GetMFAStatusResponse, error) {
+
+	user, err := agent.mfaUser(ctx, req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.MfaEnabled {
+		return &GetMFAStatusResponse{}, nil
+	}
+
+	remaining, err := agent.TokenConn.CountRecoveryCodes(ctx, user.Id.String())
+	if err != nil {
+		return nil, internalError(err)
+	}
+
+	return &GetMFAStatusResponse{MfaEnabled: true, RecoveryCodesRemaining: remaining}, nil
+}
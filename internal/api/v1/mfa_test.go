@@ -0,0 +1,110 @@
+package v1
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+	"google.golang.org/grpc/codes"
+)
+
+// enable MFA for user with its access token and return recovery codes
+func enableMFA(t *testing.T, agent *UserAgent, userId, accessToken string) []string {
+	t.Helper()
+	ctx := withBearer(accessToken)
+
+	enrolled, err := agent.EnrollMFA(ctx, &EnrollMFARequest{UserId: userId, Password: testPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := totp.GenerateCode(enrolled.GetSecret(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	confirmed, err := agent.ConfirmMFA(ctx, &ConfirmMFARequest{UserId: userId, Code: code})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return confirmed.GetRecoveryCodes()
+}
+
+func TestMFAChallengeWithRecoveryCode(t *testing.T) {
+	agent := newTestAgent(t)
+	ctx := context.Background()
+	id := createUser(t, agent, "ivan@example.com")
+	session := login(t, agent, "ivan@example.com")
+	recoveryCodes := enableMFA(t, agent, id, session.GetAccessToken())
+
+	if len(recoveryCodes) != agent.MFA.RecoveryCodes() {
+		t.Fatalf("got %d recovery codes", len(recoveryCodes))
+	}
+
+	challenge := login(t, agent, "ivan@example.com")
+	if !challenge.GetMfaRequired() || challenge.GetAccessToken() != "" {
+		t.Fatalf("tokens issued without second factor: %v", challenge)
+	}
+
+	resp, err := agent.VerifyMFA(ctx, &VerifyMFARequest{MfaToken: challenge.GetMfaToken(), Code: recoveryCodes[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetAccessToken() == "" || resp.GetRecoveryCodesRemaining() != int64(len(recoveryCodes)-1) {
+		t.Fatalf("unexpected response: %v", resp)
+	}
+
+	// challenge works once
+	_, err = agent.VerifyMFA(ctx, &VerifyMFARequest{MfaToken: challenge.GetMfaToken(), Code: recoveryCodes[1]})
+	assertCode(t, err, codes.Unauthenticated)
+
+	// recovery code works once
+	challenge = login(t, agent, "ivan@example.com")
+	_, err = agent.VerifyMFA(ctx, &VerifyMFARequest{MfaToken: challenge.GetMfaToken(), Code: recoveryCodes[0]})
+	assertCode(t, err, codes.Unauthenticated)
+
+	status, err := agent.GetMFAStatus(withBearer(resp.GetAccessToken()), &GetMFAStatusRequest{UserId: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.GetMfaEnabled() || status.GetRecoveryCodesRemaining() != int64(len(recoveryCodes)-1) {
+		t.Fatalf("unexpected status: %v", status)
+	}
+}
+
+func TestMFAManagementRequiresOwnerOrAdmin(t *testing.T) {
+	agent := newTestAgent(t)
+	id := createUser(t, agent, "ivan@example.com")
+	createUser(t, agent, "petr@example.com")
+	createAdmin(t, agent, "admin@example.com")
+	petr := login(t, agent, "petr@example.com")
+	admin := login(t, agent, "admin@example.com")
+
+	_, err := agent.EnrollMFA(context.Background(), &EnrollMFARequest{UserId: id, Password: testPassword})
+	assertCode(t, err, codes.Unauthenticated)
+
+	_, err = agent.GetMFAStatus(context.Background(), &GetMFAStatusRequest{UserId: id})
+	assertCode(t, err, codes.Unauthenticated)
+
+	other := withBearer(petr.GetAccessToken())
+	_, err = agent.EnrollMFA(other, &EnrollMFARequest{UserId: id, Password: testPassword})
+	assertCode(t, err, codes.PermissionDenied)
+
+	_, err = agent.ConfirmMFA(other, &ConfirmMFARequest{UserId: id, Code: "000000"})
+	assertCode(t, err, codes.PermissionDenied)
+
+	_, err = agent.DisableMFA(other, &DisableMFARequest{UserId: id, Code: "000000"})
+	assertCode(t, err, codes.PermissionDenied)
+
+	_, err = agent.RegenerateRecoveryCodes(other, &RegenerateRecoveryCodesRequest{UserId: id, Code: "000000"})
+	assertCode(t, err, codes.PermissionDenied)
+
+	_, err = agent.GetMFAStatus(other, &GetMFAStatusRequest{UserId: id})
+	assertCode(t, err, codes.PermissionDenied)
+
+	if _, err = agent.GetMFAStatus(withBearer(admin.GetAccessToken()), &GetMFAStatusRequest{UserId: id}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,396 @@
+package v1
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/golang-unitied-school/useragent/internal/models"
+	"github.com/golang-unitied-school/useragent/internal/pkg/notify"
+	"github.com/golang-unitied-school/useragent/internal/pkg/tokens"
+	global "github.com/golang-unitied-school/useragent/internal/pkg/utils"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// token type hints, as in RFC 7009 and RFC 7662
+const (
+	hintAccessToken  = "access_token"
+	hintRefreshToken = "refresh_token"
+)
+
+// pair of tokens returned to client after login or refresh
+type issuedTokens struct {
+	accessToken  string
+	expiresAt    time.Time
+	refreshToken string
+}
+
+// inner func for issuing access token and new refresh token;
+// a zero familyId starts a new token family
+func (agent *UserAgent) issueTokens(user models.User, familyId uuid.UUID) (issuedTokens, *models.RefreshToken, error) {
+	var issued issuedTokens
+
+	accessToken, expiresAt, err := agent.Tokens.Issue(user)
+	if err != nil {
+		return issued, nil, err
+	}
+
+	refreshToken, hash, refreshExpiresAt, err := agent.Tokens.NewRefreshToken()
+	if err != nil {
+		return issued, nil, err
+	}
+
+	if familyId == uuid.Nil {
+		familyId = uuid.New()
+	}
+
+	issued = issuedTokens{
+		accessToken:  accessToken,
+		expiresAt:    expiresAt,
+		refreshToken: refreshToken,
+	}
+
+	return issued, &models.RefreshToken{
+		Id:        uuid.New(),
+		UserId:    user.Id,
+		FamilyId:  familyId,
+		TokenHash: hash,
+		ExpiresAt: refreshExpiresAt,
+	}, nil
+}
+
+// exchange refresh token for new pair; reuse of rotated token revokes whole family
+func (agent *UserAgent) RefreshToken(ctx context.Context, req *RefreshTokenRequest) (*RefreshTokenResponse, error) {
+
+	if req.GetRefreshToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, global.ErrorEmptyToken.Error())
+	}
+
+	current, err := agent.TokenConn.GetRefreshToken(ctx, tokens.HashToken(req.GetRefreshToken()))
+	if err != nil {
+		if err == global.ErrorTokenNotFound {
+			return nil, status.Error(codes.Unauthenticated, global.ErrorTokenNotFound.Error())
+		}
+		return nil, internalError(err)
+	}
+
+	if current.RotatedAt != nil {
+		return nil, agent.revokeReusedFamily(ctx, current)
+	}
+
+	if !usableRefreshToken(current) {
+		return nil, status.Error(codes.Unauthenticated, global.ErrorTokenExpired.Error())
+	}
+
+	user, err := agent.DBConn.GetById(ctx, current.UserId.String())
+	if err != nil {
+		if err == global.ErrorUserNotFound {
+			return nil, status.Error(codes.Unauthenticated, global.ErrorUnauthenticated.Error())
+		}
+		return nil, internalError(err)
+	}
+
+	issued, next, err := agent.issueTokens(user, current.FamilyId)
+	if err != nil {
+		return nil, internalError(err)
+	}
+
+	err = agent.TokenConn.RotateRefreshToken(ctx, current.Id.String(), next)
+	if err != nil {
+		if err == global.ErrorTokenReused {
+			return nil, agent.revokeReusedFamily(ctx, current)
+		}
+		return nil, internalError(err)
+	}
+
+	return &RefreshTokenResponse{
+		AccessToken:  issued.accessToken,
+		TokenType:    tokens.TokenType,
+		ExpiresAt:    timestamppb.New(issued.expiresAt),
+		RefreshToken: issued.refreshToken,
+	}, nil
+}
+
+func (agent *UserAgent) revokeReusedFamily(ctx context.Context, token models.RefreshToken) error {
+	log.Printf("refresh token reuse detected for user %s, revoking family %s", token.UserId, token.FamilyId)
+
+	if err := agent.TokenConn.RevokeRefreshFamily(ctx, token.FamilyId.String(), ""); err != nil {
+		return internalError(err)
+	}
+
+	return status.Error(codes.Unauthenticated, global.ErrorTokenReused.Error())
+}
+
+// inner func for checking refresh token is neither rotated, revoked nor expired
+func usableRefreshToken(token models.RefreshToken) bool {
+	return token.RotatedAt == nil && token.RevokedAt == nil && time.Now().Before(token.ExpiresAt)
+}
+
+// inner func for introspection of access token; returns nil if token is not active
+func (agent *UserAgent) introspectAccessToken(ctx context.Context, token string) (*IntrospectTokenResponse, error) {
+	claims, err := agent.Tokens.Parse(token)
+	if err != nil {
+		return nil, nil
+	}
+
+	revoked, err := agent.TokenConn.IsAccessTokenRevoked(ctx, claims.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if revoked {
+		return nil, nil
+	}
+
+	hasUser, err := agent.findUserByUUID(ctx, claims.Subject)
+	if err != nil && err != global.ErrorInvalidFormat {
+		return nil, err
+	}
+
+	if !hasUser {
+		return nil, nil
+	}
+
+	return &IntrospectTokenResponse{
+		Active:    true,
+		Subject:   claims.Subject,
+		Email:     claims.Email,
+		Role:      claims.Role,
+		ExpiresAt: timestamppb.New(claims.ExpiresAt.Time),
+		TokenType: hintAccessToken,
+	}, nil
+}
+
+// user calling the method, from bearer access token; zero if the call has no token
+type caller struct {
+	id   string
+	role string
+}
+
+// whether caller is the owner of account userId or has one of roles
+func (c caller) may(userId string, roles ...string) bool {
+	if c.id == userId {
+		return true
+	}
+
+	for _, role := range roles {
+		if c.role == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// inner func for user calling the method: subject of active bearer access token
+// from authorization metadata; zero caller if the call has no token
+func (agent *UserAgent) caller(ctx context.Context) (caller, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return caller{}, nil
+	}
+
+	scheme, token, ok := strings.Cut(values[0], " ")
+	if !ok || !strings.EqualFold(scheme, tokens.TokenType) {
+		return caller{}, status.Error(codes.Unauthenticated, global.ErrorInvalidToken.Error())
+	}
+
+	info, err := agent.introspectAccessToken(ctx, strings.TrimSpace(token))
+	if err != nil {
+		return caller{}, internalError(err)
+	}
+
+	if info == nil {
+		return caller{}, status.Error(codes.Unauthenticated, global.ErrorInvalidToken.Error())
+	}
+
+	return caller{id: info.Subject, role: info.Role}, nil
+}
+
+// inner func for id of user calling the method; empty if the call has no token
+func (agent *UserAgent) actor(ctx context.Context) (string, error) {
+	c, err := agent.caller(ctx)
+	return c.id, err
+}
+
+// inner func for caller of methods that must not be called anonymously
+func (agent *UserAgent) requireCaller(ctx context.Context) (caller, error) {
+	c, err := agent.caller(ctx)
+	if err != nil {
+		return c, err
+	}
+
+	if c.id == "" {
+		return c, status.Error(codes.Unauthenticated, global.ErrorAuthRequired.Error())
+	}
+
+	return c, nil
+}
+
+// inner func for actor of methods that must not be called anonymously
+func (agent *UserAgent) requireActor(ctx context.Context) (string, error) {
+	c, err := agent.requireCaller(ctx)
+	return c.id, err
+}
+
+// inner func for methods managing account userId: caller must be its owner or have one of roles
+func (agent *UserAgent) authorize(ctx context.Context, userId string, roles ...string) (caller, error) {
+	c, err := agent.requireCaller(ctx)
+	if err != nil {
+		return c, err
+	}
+
+	if !c.may(userId, roles...) {
+		return c, status.Error(codes.PermissionDenied, global.ErrorPermissionDenied.Error())
+	}
+
+	return c, nil
+}
+
+// inner func for introspection of refresh token; returns nil if token is not active
+func (agent *UserAgent) introspectRefreshToken(ctx context.Context, token string) (*IntrospectTokenResponse, error) {
+	row, err := agent.TokenConn.GetRefreshToken(ctx, tokens.HashToken(token))
+	if err != nil {
+		if err == global.ErrorTokenNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if !usableRefreshToken(row) {
+		return nil, nil
+	}
+
+	user, err := agent.DBConn.GetById(ctx, row.UserId.String())
+	if err != nil {
+		if err == global.ErrorUserNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &IntrospectTokenResponse{
+		Active:    true,
+		Subject:   user.Id.String(),
+		Email:     user.Email,
+		Role:      user.Role,
+		ExpiresAt: timestamppb.New(row.ExpiresAt),
+		TokenType: hintRefreshToken,
+	}, nil
+}
+
+// report whether token is active; unknown, expired and revoked tokens are inactive
+func (agent *UserAgent) IntrospectToken(ctx context.Context, req *IntrospectTokenRequest) (*IntrospectTokenResponse, error) {
+
+	if req.GetToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, global.ErrorEmptyToken.Error())
+	}
+
+	var (
+		resp *IntrospectTokenResponse
+		err  error
+	)
+
+	if req.GetTokenTypeHint() != hintRefreshToken {
+		resp, err = agent.introspectAccessToken(ctx, req.GetToken())
+	}
+
+	if resp == nil && err == nil {
+		resp, err = agent.introspectRefreshToken(ctx, req.GetToken())
+	}
+
+	if err != nil {
+		return nil, internalError(err)
+	}
+
+	if resp == nil {
+		return &IntrospectTokenResponse{Active: false}, nil
+	}
+
+	return resp, nil
+}
+
+// revoke access token by jti or refresh token with its family; caller must present
+// bearer token of the token owner or of admin or service, unknown tokens are ignored, as in RFC 7009
+func (agent *UserAgent) RevokeToken(ctx context.Context, req *RevokeTokenRequest) (*emptypb.Empty, error) {
+
+	if req.GetToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, global.ErrorEmptyToken.Error())
+	}
+
+	by, err := agent.requireCaller(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetTokenTypeHint() != hintRefreshToken {
+		if claims, err := agent.Tokens.Parse(req.GetToken()); err == nil {
+			if !by.may(claims.Subject, models.RoleAdmin, models.RoleService) {
+				return nil, status.Error(codes.PermissionDenied, global.ErrorNotTokenOwner.Error())
+			}
+
+			userId, _ := uuid.Parse(claims.Subject)
+			err = agent.TokenConn.RevokeAccessToken(ctx, &models.RevokedToken{
+				Jti:       claims.ID,
+				UserId:    userId,
+				ExpiresAt: claims.ExpiresAt.Time,
+				RevokedBy: by.id,
+			})
+			if err != nil {
+				return nil, internalError(err)
+			}
+			return &emptypb.Empty{}, nil
+		}
+	}
+
+	row, err := agent.TokenConn.GetRefreshToken(ctx, tokens.HashToken(req.GetToken()))
+	if err != nil {
+		if err == global.ErrorTokenNotFound {
+			return &emptypb.Empty{}, nil
+		}
+		return nil, internalError(err)
+	}
+
+	if !by.may(row.UserId.String(), models.RoleAdmin, models.RoleService) {
+		return nil, status.Error(codes.PermissionDenied, global.ErrorNotTokenOwner.Error())
+	}
+
+	if err = agent.TokenConn.RevokeRefreshFamily(ctx, row.FamilyId.String(), by.id); err != nil {
+		return nil, internalError(err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// inner func for issuing single-use token sent by email; previous tokens of user
+// for the same purpose stop working
+func (agent *UserAgent) issueActionToken(ctx context.Context, user models.User, purpose string, ttl time.Duration) (notify.Data, error) {
+	if err := agent.TokenConn.RevokeActionTokens(ctx, user.Id.String(), purpose); err != nil {
+		return notify.Data{}, err
+	}
+
+	token, hash, err := tokens.NewOpaqueToken()
+	if err != nil {
+		return notify.Data{}, err
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	err = agent.TokenConn.CreateActionToken(ctx, &models.ActionToken{
+		UserId:    user.Id,
+		Purpose:   purpose,
+		TokenHash: hash,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return notify.Data{}, err
+	}
+
+	return notify.Data{User: user, Token: token, ExpiresAt: expiresAt}, nil
+}
@@ -0,0 +1,114 @@
+package v1
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang-unitied-school/useragent/internal/models"
+	"google.golang.org/grpc/codes"
+)
+
+func TestRefreshTokenRotation(t *testing.T) {
+	agent := newTestAgent(t)
+	ctx := context.Background()
+	createUser(t, agent, "ivan@example.com")
+	first := login(t, agent, "ivan@example.com")
+
+	second, err := agent.RefreshToken(ctx, &RefreshTokenRequest{RefreshToken: first.GetRefreshToken()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.GetRefreshToken() == first.GetRefreshToken() {
+		t.Fatal("refresh token was not rotated")
+	}
+
+	third, err := agent.RefreshToken(ctx, &RefreshTokenRequest{RefreshToken: second.GetRefreshToken()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reuse of rotated token revokes the whole family, also the newest token
+	_, err = agent.RefreshToken(ctx, &RefreshTokenRequest{RefreshToken: first.GetRefreshToken()})
+	assertCode(t, err, codes.Unauthenticated)
+
+	_, err = agent.RefreshToken(ctx, &RefreshTokenRequest{RefreshToken: third.GetRefreshToken()})
+	assertCode(t, err, codes.Unauthenticated)
+}
+
+func TestRevokeTokenRequiresOwner(t *testing.T) {
+	agent := newTestAgent(t)
+	ivanId := createUser(t, agent, "ivan@example.com")
+	createUser(t, agent, "petr@example.com")
+	ivan := login(t, agent, "ivan@example.com")
+	petr := login(t, agent, "petr@example.com")
+
+	_, err := agent.RevokeToken(context.Background(), &RevokeTokenRequest{Token: ivan.GetAccessToken()})
+	assertCode(t, err, codes.Unauthenticated)
+
+	_, err = agent.RevokeToken(withBearer(petr.GetAccessToken()), &RevokeTokenRequest{Token: ivan.GetAccessToken()})
+	assertCode(t, err, codes.PermissionDenied)
+
+	_, err = agent.RevokeToken(withBearer(petr.GetAccessToken()), &RevokeTokenRequest{Token: ivan.GetRefreshToken(), TokenTypeHint: hintRefreshToken})
+	assertCode(t, err, codes.PermissionDenied)
+
+	if _, err = agent.RevokeToken(withBearer(ivan.GetAccessToken()), &RevokeTokenRequest{Token: ivan.GetRefreshToken(), TokenTypeHint: hintRefreshToken}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = agent.RevokeToken(withBearer(ivan.GetAccessToken()), &RevokeTokenRequest{Token: ivan.GetAccessToken()}); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := agent.IntrospectToken(context.Background(), &IntrospectTokenRequest{Token: ivan.GetAccessToken()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.GetActive() {
+		t.Fatal("revoked access token is active")
+	}
+
+	data, err := agent.DBConn.GetUserData(context.Background(), ivanId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.RevokedTokens) != 1 || data.RevokedTokens[0].RevokedBy != ivanId {
+		t.Fatalf("revoked token actor is not stored: %+v", data.RevokedTokens)
+	}
+	for _, token := range data.RefreshTokens {
+		if token.RevokedBy != ivanId {
+			t.Fatalf("refresh token actor is not stored: %+v", token)
+		}
+	}
+}
+
+func TestRevokeTokenByService(t *testing.T) {
+	agent := newTestAgent(t)
+	ivanId := createUser(t, agent, "ivan@example.com")
+	ivan := login(t, agent, "ivan@example.com")
+
+	resp, err := agent.CreateUser(context.Background(), &CreateUserRequest{
+		Name:     "Billing",
+		Surname:  "Service",
+		Email:    "billing@example.com",
+		Password: testPassword,
+		Role:     models.RoleService,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := login(t, agent, "billing@example.com")
+
+	if _, err = agent.RevokeToken(withBearer(service.GetAccessToken()), &RevokeTokenRequest{Token: ivan.GetRefreshToken()}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = agent.RefreshToken(context.Background(), &RefreshTokenRequest{RefreshToken: ivan.GetRefreshToken()})
+	assertCode(t, err, codes.Unauthenticated)
+
+	data, err := agent.DBConn.GetUserData(context.Background(), ivanId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.RefreshTokens) != 1 || data.RefreshTokens[0].RevokedBy != resp.GetUserId() {
+		t.Fatalf("service is not stored as revoking actor: %+v", data.RefreshTokens)
+	}
+}
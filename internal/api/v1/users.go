@@ -2,56 +2,129 @@ package v1
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"log"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/golang-unitied-school/useragent/config"
 	db "github.com/golang-unitied-school/useragent/internal/interfaces"
 	"github.com/golang-unitied-school/useragent/internal/models"
+	"github.com/golang-unitied-school/useragent/internal/pkg/mfa"
+	"github.com/golang-unitied-school/useragent/internal/pkg/notify"
+	"github.com/golang-unitied-school/useragent/internal/pkg/tokens"
 	global "github.com/golang-unitied-school/useragent/internal/pkg/utils"
-	"github.com/sethvargo/go-password/password"
+	"github.com/google/uuid"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
 type UserAgent struct {
 	UnimplementedUserAgentServer
-	DBConn db.UserDataManager
+	DBConn    db.UserDataManager
+	TokenConn db.TokenDataManager
+	Tokens    *tokens.Manager
+	Auth      config.AuthConfig
+	Mailer    *notify.Mailer
+	MFA       *mfa.Manager
+}
+
+// map storage error to gRPC status; cancelled and timed out requests keep their own codes
+func internalError(err error) error {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// inner func for running handler body in a storage transaction;
+// tx agent must be used for all storage calls inside fn
+func (agent *UserAgent) inTx(ctx context.Context, fn func(tx *UserAgent) error) error {
+	err := agent.DBConn.WithTx(ctx, func(conn db.DataManager) error {
+		tx := *agent
+		tx.DBConn = conn
+		tx.TokenConn = conn
+		return fn(&tx)
+	})
+
+	if _, ok := status.FromError(err); err != nil && !ok {
+		return internalError(err)
+	}
+
+	return err
+}
+
+// inner func for account emails that must not fail the request, e.g. after commit
+func (agent *UserAgent) notify(ctx context.Context, to, template string, data notify.Data) {
+	if err := agent.Mailer.Send(ctx, to, template, data); err != nil {
+		log.Printf("error while sending %s email to %s: %s", template, to, err.Error())
+	}
+}
+
+// inner func for emails whose sending must not show in response time,
+// e.g. answers to requests by email that must not reveal if the account exists
+func (agent *UserAgent) notifyAsync(to, template string, data notify.Data) {
+	agent.Mailer.SendAsync(to, template, data, func(err error) {
+		if err != nil {
+			log.Printf("error while sending %s email to %s: %s", template, to, err.Error())
+		}
+	})
 }
 
 // inner func for check user by creds
-func (agent *UserAgent) findUserByEmail(email string) (bool, error) {
+func (agent *UserAgent) findUserByEmail(ctx context.Context, email string) (bool, error) {
 
 	if email == "" {
 		return false, global.ErrorEmptyLogin
-	} else {
-		if global.CheckEmail(email) {
-			_, err := agent.DBConn.GetByEmail(email)
+	}
 
-			if err == global.ErrorUserNotFound {
-				return false, nil
-			}
+	if !global.CheckEmail(global.NormalizeEmail(email)) {
+		return false, global.ErrorInvalidEmailFormat
+	}
 
-			if err != nil {
-				return false, err
-			}
+	return agent.emailInUse(ctx, email, uuid.Nil)
+}
 
-		} else {
-			return false, global.ErrorInvalidEmailFormat
-		}
+// inner func for email uniqueness check; email of deleted account stays taken
+// unless AUTH_DELETED_EMAIL_POLICY=reuse
+func (agent *UserAgent) emailInUse(ctx context.Context, email string, self uuid.UUID) (bool, error) {
+	lookup := agent.DBConn.GetByEmailWithDeleted
+	if agent.Auth.AUTH_DELETED_EMAIL_POLICY == config.DeletedEmailReuse {
+		lookup = agent.DBConn.GetByEmail
 	}
 
-	return true, nil
+	user, err := lookup(ctx, email)
+	if err == global.ErrorUserNotFound {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return user.Id != self, nil
 }
 
-func (agent *UserAgent) findUserByUUID(userId string) (bool, error) {
+func (agent *UserAgent) findUserByUUID(ctx context.Context, userId string) (bool, error) {
 
 	if !global.IsValidUUID(userId) {
 		return false, global.ErrorInvalidFormat
 	}
 
-	_, err := agent.DBConn.GetById(userId)
+	_, err := agent.DBConn.GetById(ctx, userId)
 
 	if err == global.ErrorUserNotFound {
 		return false, nil
@@ -64,15 +137,15 @@ func (agent *UserAgent) findUserByUUID(userId string) (bool, error) {
 	return true, nil
 }
 
-func (agent *UserAgent) checkPrerequisites(req *CreateUserRequest) error {
+func (agent *UserAgent) checkPrerequisites(ctx context.Context, req *CreateUserRequest) error {
 
 	if req.GetName() == "" || req.GetSurname() == "" {
 		return status.Error(codes.InvalidArgument, global.ErrorEmptyCredentials.Error())
 	}
 
-	hasUser, err := agent.findUserByEmail(req.GetEmail())
+	hasUser, err := agent.findUserByEmail(ctx, req.GetEmail())
 	if err != nil {
-		return status.Error(codes.Internal, err.Error())
+		return internalError(err)
 	}
 
 	if hasUser {
@@ -92,82 +165,227 @@ func (agent *UserAgent) checkPrerequisites(req *CreateUserRequest) error {
 
 func (agent *UserAgent) CreateUser(ctx context.Context, req *CreateUserRequest) (*CreateUserResponse, error) {
 
-	if err := agent.checkPrerequisites(req); err != nil {
-		return nil, err
+	// bcrypt is slow, so hash before the transaction; invalid password
+	// is reported by checkPrerequisites in its turn
+	var hash string
+	if global.ValidatePassword(req.GetPassword()) {
+		var err error
+		if hash, err = global.EncodingPassword(req.GetPassword()); err != nil {
+			return nil, internalError(err)
+		}
 	}
 
-	hash, err := global.EncodingPassword(req.GetPassword())
-	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
-	}
+	var (
+		userID       string
+		verification notify.Data
+	)
+	err := agent.inTx(ctx, func(tx *UserAgent) error {
+		if err := tx.checkPrerequisites(ctx, req); err != nil {
+			return err
+		}
 
-	newUser := models.User{
-		Name:     req.GetName(),
-		Surname:  req.GetSurname(),
-		Email:    req.GetEmail(),
-		Password: hash,
-		Role:     req.GetRole(),
-	}
+		newUser := models.User{
+			Name:     req.GetName(),
+			Surname:  req.GetSurname(),
+			Email:    strings.TrimSpace(req.GetEmail()),
+			Password: hash,
+			Role:     req.GetRole(),
+		}
+
+		var err error
+		userID, err = tx.DBConn.Create(ctx, &newUser)
 
-	userID, err := agent.DBConn.Create(&newUser)
+		if errors.Is(err, global.ErrorUserExists) {
+			return status.Error(codes.AlreadyExists, global.ErrorUserExists.Error())
+		}
+
+		if err != nil {
+			return internalError(err)
+		}
+
+		if verification, err = tx.issueVerification(ctx, newUser); err != nil {
+			return internalError(err)
+		}
+
+		return nil
+	})
 
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, err
 	}
 
+	agent.notify(ctx, verification.User.Email, notify.TemplateVerifyEmail, verification)
+
 	return &CreateUserResponse{UserId: userID}, nil
 }
 
 // update some user`s fields by id
 func (agent *UserAgent) UpdateUser(ctx context.Context, req *UpdateUserRequest) (*emptypb.Empty, error) {
 
-	hasUser, err := agent.findUserByUUID(req.GetUserId())
-	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+	if req.GetEmail() != "" {
+		if !global.CheckEmail(global.NormalizeEmail(req.GetEmail())) {
+			return nil, status.Error(codes.InvalidArgument, global.ErrorInvalidEmailFormat.Error())
+		}
 	}
 
-	if !hasUser {
-		return nil, status.Error(codes.NotFound, global.ErrorUserNotFound.Error())
+	if !global.IsValidUUID(req.GetUserId()) {
+		return nil, status.Error(codes.InvalidArgument, global.ErrorInvalidFormat.Error())
 	}
 
-	if req.GetEmail() != "" {
-		if !global.CheckEmail(req.GetEmail()) {
-			return nil, status.Error(codes.InvalidArgument, global.ErrorInvalidEmailFormat.Error())
+	var (
+		current      models.User
+		verification notify.Data
+	)
+	err := agent.inTx(ctx, func(tx *UserAgent) error {
+		var err error
+		current, err = tx.DBConn.GetById(ctx, req.GetUserId())
+		if err == global.ErrorUserNotFound {
+			return status.Error(codes.NotFound, global.ErrorUserNotFound.Error())
 		}
-	}
 
-	err = agent.DBConn.Update(
-		req.GetUserId(),
-		req.GetName(),
-		req.GetSurname(),
-		req.GetEmail(),
-		req.GetRole())
+		if err != nil {
+			return internalError(err)
+		}
+
+		if req.GetEmail() != "" {
+			taken, err := tx.emailInUse(ctx, req.GetEmail(), current.Id)
+			if err != nil {
+				return internalError(err)
+			}
+
+			if taken {
+				return status.Error(codes.AlreadyExists, global.ErrorUserExists.Error())
+			}
+		}
+
+		err = tx.DBConn.Update(
+			ctx,
+			req.GetUserId(),
+			req.GetName(),
+			req.GetSurname(),
+			strings.TrimSpace(req.GetEmail()),
+			req.GetRole())
+
+		if errors.Is(err, global.ErrorUserExists) {
+			return status.Error(codes.AlreadyExists, global.ErrorUserExists.Error())
+		}
+
+		if err != nil {
+			return internalError(err)
+		}
+
+		// new address must be verified again
+		if req.GetEmail() != "" && global.NormalizeEmail(req.GetEmail()) != current.EmailNormalized {
+			if err = tx.DBConn.SetEmailVerified(ctx, current.Id.String(), false); err != nil {
+				return internalError(err)
+			}
+
+			updated := current
+			updated.Email = strings.TrimSpace(req.GetEmail())
+			if verification, err = tx.issueVerification(ctx, updated); err != nil {
+				return internalError(err)
+			}
+		}
+
+		return nil
+	})
 
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, err
+	}
+
+	// old address is told about the change, new one gets verification
+	if verification.Token != "" {
+		agent.notify(ctx, current.Email, notify.TemplateEmailChanged, notify.Data{User: current, NewEmail: verification.User.Email})
+		agent.notify(ctx, verification.User.Email, notify.TemplateVerifyEmail, verification)
 	}
 
 	return &emptypb.Empty{}, nil
 }
 
 // delete user by id
+// soft delete; caller from bearer token is stored as the deleting actor, sessions are revoked
 func (agent *UserAgent) DeleteUser(ctx context.Context, req *DeleteUserRequest) (*emptypb.Empty, error) {
-	hasUser, err := agent.findUserByUUID(req.GetUserId())
+	actor, err := agent.actor(ctx)
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, err
 	}
 
-	if !hasUser {
-		return nil, status.Error(codes.NotFound, global.ErrorUserNotFound.Error())
-	}
+	err = agent.inTx(ctx, func(tx *UserAgent) error {
+		hasUser, err := tx.findUserByUUID(ctx, req.GetUserId())
+		if err != nil {
+			return internalError(err)
+		}
+
+		if !hasUser {
+			return status.Error(codes.NotFound, global.ErrorUserNotFound.Error())
+		}
+
+		if err = tx.DBConn.Delete(ctx, req.GetUserId(), actor); err != nil {
+			return internalError(err)
+		}
+
+		if err = tx.TokenConn.RevokeUserRefreshTokens(ctx, req.GetUserId()); err != nil {
+			return internalError(err)
+		}
+		return nil
+	})
 
-	err = agent.DBConn.Delete(req.GetUserId())
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, err
 	}
 	return &emptypb.Empty{}, nil
 }
 
+// undo DeleteUser within AUTH_RESTORE_WINDOW; fails if email was taken by another account meanwhile
+func (agent *UserAgent) RestoreUser(ctx context.Context, req *RestoreUserRequest) (*GetUserResponse, error) {
+
+	if !global.IsValidUUID(req.GetUserId()) {
+		return nil, status.Error(codes.InvalidArgument, global.ErrorInvalidFormat.Error())
+	}
+
+	var restored models.User
+	err := agent.inTx(ctx, func(tx *UserAgent) error {
+		user, err := tx.DBConn.GetByIdWithDeleted(ctx, req.GetUserId())
+		if err == global.ErrorUserNotFound {
+			return status.Error(codes.NotFound, global.ErrorUserNotFound.Error())
+		}
+
+		if err != nil {
+			return internalError(err)
+		}
+
+		if user.IsDeleted == 0 {
+			return status.Error(codes.FailedPrecondition, global.ErrorUserNotDeleted.Error())
+		}
+
+		if user.DeletedAt == nil || time.Since(*user.DeletedAt) > tx.Auth.AUTH_RESTORE_WINDOW {
+			return status.Error(codes.FailedPrecondition, global.ErrorRestoreWindowExpired.Error())
+		}
+
+		err = tx.DBConn.Restore(ctx, req.GetUserId())
+		if errors.Is(err, global.ErrorUserExists) {
+			return status.Error(codes.AlreadyExists, global.ErrorEmailReused.Error())
+		}
+
+		if err != nil {
+			return internalError(err)
+		}
+
+		if restored, err = tx.DBConn.GetById(ctx, req.GetUserId()); err != nil {
+			return internalError(err)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return userResponse(restored), nil
+}
+
 // find user by uuid
 func (agent *UserAgent) GetUserById(ctx context.Context, req *GetUserRequest) (*GetUserResponse, error) {
 
@@ -175,49 +393,177 @@ func (agent *UserAgent) GetUserById(ctx context.Context, req *GetUserRequest) (*
 		return nil, status.Error(codes.InvalidArgument, global.ErrorInvalidFormat.Error())
 	}
 
-	rowUser, err := agent.DBConn.GetById(req.GetUserId())
+	lookup := agent.DBConn.GetById
+	if req.GetIncludeDeleted() {
+		lookup = agent.DBConn.GetByIdWithDeleted
+	}
+
+	rowUser, err := lookup(ctx, req.GetUserId())
 	if err != nil {
 		if err == global.ErrorUserNotFound {
 			return nil, status.Error(codes.NotFound, global.ErrorUserNotFound.Error())
 		}
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, internalError(err)
 	}
 
+	return userResponse(rowUser), nil
+}
+
+func userResponse(row models.User) *GetUserResponse {
 	return &GetUserResponse{
-		UserId:    rowUser.Id.String(),
-		Name:      rowUser.Name,
-		Surname:   rowUser.Surname,
-		Email:     rowUser.Email,
-		Role:      rowUser.Role,
-		CreatedAt: timestamppb.New(rowUser.CreatedAt),
-		IsDeleted: rowUser.IsDeleted,
-	}, nil
+		UserId:        row.Id.String(),
+		Name:          row.Name,
+		Surname:       row.Surname,
+		Email:         row.Email,
+		Role:          row.Role,
+		CreatedAt:     timestamppb.New(row.CreatedAt),
+		IsDeleted:     row.IsDeleted,
+		EmailVerified: row.EmailVerified,
+		MfaEnabled:    row.MfaEnabled,
+		LockedUntil:   lockedUntil(row),
+		DeletedAt:     deletedAt(row),
+		DeletedBy:     row.DeletedBy,
+	}
+}
+
+// nil if account is not locked now
+func lockedUntil(row models.User) *timestamppb.Timestamp {
+	if !isLocked(row) {
+		return nil
+	}
+
+	return timestamppb.New(*row.LockedUntil)
+}
+
+func deletedAt(row models.User) *timestamppb.Timestamp {
+	if row.DeletedAt == nil {
+		return nil
+	}
+
+	return timestamppb.New(*row.DeletedAt)
+}
+
+// page token is base64 of "<created_at>|<id>" of the last user on page
+func encodePageToken(row models.User) string {
+	raw := row.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + row.Id.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodePageToken(token string) (*models.UserCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, global.ErrorInvalidPageToken
+	}
+
+	createdAt, id, found := strings.Cut(string(raw), "|")
+	if !found {
+		return nil, global.ErrorInvalidPageToken
+	}
+
+	var cursor models.UserCursor
+	if cursor.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt); err != nil {
+		return nil, global.ErrorInvalidPageToken
+	}
+	if cursor.Id, err = uuid.Parse(id); err != nil {
+		return nil, global.ErrorInvalidPageToken
+	}
+
+	return &cursor, nil
+}
+
+// list users page by page, newest first unless ascending order requested
+func (agent *UserAgent) ListUsers(ctx context.Context, req *ListUsersRequest) (*ListUsersResponse, error) {
+
+	query := models.ListUsersQuery{
+		Role:      req.GetRole(),
+		Ascending: req.GetOrder() == SortOrder_SORT_ORDER_CREATED_ASC,
+		Limit:     defaultPageSize,
+	}
+
+	if req.GetPageSize() < 0 {
+		return nil, status.Error(codes.InvalidArgument, global.ErrorInvalidFormat.Error())
+	}
+	if req.GetPageSize() > 0 {
+		query.Limit = int(req.GetPageSize())
+	}
+	if query.Limit > maxPageSize {
+		query.Limit = maxPageSize
+	}
+
+	switch req.GetDeleted() {
+	case DeletedFilter_DELETED_FILTER_ACTIVE:
+		active := int32(0)
+		query.IsDeleted = &active
+	case DeletedFilter_DELETED_FILTER_DELETED:
+		deleted := int32(1)
+		query.IsDeleted = &deleted
+	}
+
+	if req.GetCreatedFrom() != nil {
+		query.CreatedFrom = req.GetCreatedFrom().AsTime()
+	}
+	if req.GetCreatedTo() != nil {
+		query.CreatedTo = req.GetCreatedTo().AsTime()
+	}
+
+	if req.GetPageToken() != "" {
+		cursor, err := decodePageToken(req.GetPageToken())
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		query.After = cursor
+	}
+
+	// one extra row tells whether there is a next page
+	query.Limit++
+	rows, total, err := agent.DBConn.List(ctx, query)
+	if err != nil {
+		return nil, internalError(err)
+	}
+
+	resp := &ListUsersResponse{TotalCount: total}
+	if len(rows) == query.Limit {
+		rows = rows[:len(rows)-1]
+		resp.NextPageToken = encodePageToken(rows[len(rows)-1])
+	}
+
+	for _, row := range rows {
+		resp.Users = append(resp.Users, userResponse(row))
+	}
+
+	return resp, nil
 }
 
 // find user by email
 func (agent *UserAgent) GetUserByEmail(ctx context.Context, req *GetUserByEmailRequest) (*GetUserByEmailResponse, error) {
 
-	if !global.CheckEmail(req.GetEmail()) {
+	if !global.CheckEmail(global.NormalizeEmail(req.GetEmail())) {
 		return nil, status.Error(codes.InvalidArgument, global.ErrorInvalidEmailFormat.Error())
 	}
 
-	rowUser, err := agent.DBConn.GetByEmail(req.GetEmail())
+	lookup := agent.DBConn.GetByEmail
+	if req.GetIncludeDeleted() {
+		lookup = agent.DBConn.GetByEmailWithDeleted
+	}
+
+	rowUser, err := lookup(ctx, global.NormalizeEmail(req.GetEmail()))
 	if err != nil {
 		if err.Error() == global.ErrorUserNotFound.Error() {
 			return nil, status.Error(codes.NotFound, global.ErrorUserNotFound.Error())
 		} else {
-			return nil, status.Error(codes.Internal, err.Error())
+			return nil, internalError(err)
 		}
 	}
 
 	return &GetUserByEmailResponse{
-		UserId:    rowUser.Id.String(),
-		Name:      rowUser.Name,
-		Surname:   rowUser.Surname,
-		Email:     rowUser.Email,
-		Role:      rowUser.Role,
-		Createdat: timestamppb.New(rowUser.CreatedAt),
-		Isdeleted: rowUser.IsDeleted,
+		UserId:        rowUser.Id.String(),
+		Name:          rowUser.Name,
+		Surname:       rowUser.Surname,
+		Email:         rowUser.Email,
+		Role:          rowUser.Role,
+		Createdat:     timestamppb.New(rowUser.CreatedAt),
+		Isdeleted:     rowUser.IsDeleted,
+		EmailVerified: rowUser.EmailVerified,
 	}, nil
 }
 
@@ -228,46 +574,95 @@ func (agent *UserAgent) AuthUser(ctx context.Context, req *AuthUserRequest) (*Au
 		err  error
 	)
 
-	if !global.CheckEmail(req.GetEmail()) {
+	if !global.CheckEmail(global.NormalizeEmail(req.GetEmail())) {
 		return nil, status.Error(codes.InvalidArgument, global.ErrorInvalidEmailFormat.Error())
 	}
 
-	user, err = agent.DBConn.GetByEmail(req.GetEmail())
+	user, err = agent.DBConn.GetByEmail(ctx, global.NormalizeEmail(req.GetEmail()))
 
 	if err != nil {
 		if err == global.ErrorUserNotFound {
 			return nil, status.Error(codes.Unauthenticated, global.ErrorUnauthenticated.Error())
 		} else {
-			return nil, status.Error(codes.Internal, err.Error())
+			return nil, internalError(err)
 		}
 	}
 
+	// locked account answers as wrong credentials, so lockout does not reveal that email exists
+	if isLocked(user) {
+		return nil, status.Error(codes.Unauthenticated, global.ErrorUnauthenticated.Error())
+	}
+
 	verify := global.ComparePasswords(req.GetPassword(), user.Password)
 
 	if !verify {
+		if err = agent.failLogin(ctx, user.Id.String()); err != nil {
+			return nil, err
+		}
 		return nil, status.Error(codes.Unauthenticated, global.ErrorUnauthenticated.Error())
 	}
 
-	return &AuthUserResponse{Verified: true}, nil
+	if agent.Auth.AUTH_REQUIRE_VERIFIED_EMAIL && !user.EmailVerified {
+		return nil, status.Error(codes.FailedPrecondition, global.ErrorEmailNotVerified.Error())
+	}
+
+	if agent.MFA.Required(user.Role) && !user.MfaEnabled {
+		return nil, status.Error(codes.FailedPrecondition, global.ErrorMFARequired.Error())
+	}
+
+	if user.MfaEnabled {
+		return agent.mfaChallenge(ctx, user)
+	}
+
+	return agent.login(ctx, user)
 }
 
-func (agent *UserAgent) ChangePassword(ctx context.Context, req *ChangePasswordRequest) (*emptypb.Empty, error) {
+// inner func for issuing tokens of new session after all factors are checked
+func (agent *UserAgent) login(ctx context.Context, user models.User) (*AuthUserResponse, error) {
+	if err := agent.resetLockout(ctx, user); err != nil {
+		return nil, internalError(err)
+	}
 
-	hasUser, err := agent.findUserByUUID(req.GetUserId())
+	issued, refresh, err := agent.issueTokens(user, uuid.Nil)
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, internalError(err)
 	}
 
-	if !hasUser {
+	if err = agent.TokenConn.CreateRefreshToken(ctx, refresh); err != nil {
+		return nil, internalError(err)
+	}
+
+	return &AuthUserResponse{
+		Verified:     true,
+		AccessToken:  issued.accessToken,
+		TokenType:    tokens.TokenType,
+		ExpiresAt:    timestamppb.New(issued.expiresAt),
+		RefreshToken: issued.refreshToken,
+	}, nil
+}
+
+func (agent *UserAgent) ChangePassword(ctx context.Context, req *ChangePasswordRequest) (*emptypb.Empty, error) {
+
+	if !global.IsValidUUID(req.GetUserId()) {
+		return nil, status.Error(codes.InvalidArgument, global.ErrorInvalidFormat.Error())
+	}
+
+	user, err := agent.DBConn.GetById(ctx, req.GetUserId())
+	if err == global.ErrorUserNotFound {
 		return nil, status.Error(codes.NotFound, global.ErrorUserNotFound.Error())
 	}
 
-	hash, err := agent.DBConn.GetPassword(req.GetUserId())
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, internalError(err)
 	}
 
-	if !global.ComparePasswords(req.OldPassword, hash) {
+	// bcrypt is slow, so old password is checked and new one is hashed before the transaction
+	current, err := agent.DBConn.GetPassword(ctx, req.GetUserId())
+	if err != nil {
+		return nil, internalError(err)
+	}
+
+	if !global.ComparePasswords(req.OldPassword, current) {
 		return nil, status.Error(codes.FailedPrecondition, global.ErrorPasswordNotMatched.Error())
 	}
 
@@ -279,29 +674,198 @@ func (agent *UserAgent) ChangePassword(ctx context.Context, req *ChangePasswordR
 		return nil, status.Error(codes.InvalidArgument, global.ErrorBadPassword.Error())
 	}
 
-	err = agent.DBConn.SetPassword(req.GetUserId(), req.GetNewPassword())
+	hash, err := global.EncodingPassword(req.GetNewPassword())
 	if err != nil {
-		if err.Error() == global.ErrorOldPassInvalid.Error() {
-			return nil, status.Error(codes.InvalidArgument, global.ErrorOldPassInvalid.Error())
-		} else {
-			return nil, status.Error(codes.Internal, err.Error())
+		return nil, internalError(err)
+	}
+
+	err = agent.inTx(ctx, func(tx *UserAgent) error {
+		// password was changed by a concurrent call after the check above
+		stored, err := tx.DBConn.GetPassword(ctx, req.GetUserId())
+		if err != nil {
+			return internalError(err)
+		}
+
+		if stored != current {
+			return status.Error(codes.FailedPrecondition, global.ErrorPasswordNotMatched.Error())
 		}
+
+		err = tx.DBConn.SetPassword(ctx, req.GetUserId(), hash)
+		if err != nil {
+			if err.Error() == global.ErrorOldPassInvalid.Error() {
+				return status.Error(codes.InvalidArgument, global.ErrorOldPassInvalid.Error())
+			} else {
+				return internalError(err)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
 	}
 
+	agent.notify(ctx, user.Email, notify.TemplatePasswordChanged, notify.Data{User: user})
+
 	return &emptypb.Empty{}, nil
 }
 
-func (agent *UserAgent) ResetPassword(ctx context.Context, req *ResetPasswordRequest) (*emptypb.Empty, error) {
-	newPass, err := password.Generate(10, 3, 3, false, false)
+// issue password reset token and send it by email;
+// response is the same whether the email is registered or not
+func (agent *UserAgent) RequestPasswordReset(ctx context.Context, req *RequestPasswordResetRequest) (*emptypb.Empty, error) {
+
+	if !global.CheckEmail(global.NormalizeEmail(req.GetEmail())) {
+		return nil, status.Error(codes.InvalidArgument, global.ErrorInvalidEmailFormat.Error())
+	}
+
+	var reset notify.Data
+	err := agent.inTx(ctx, func(tx *UserAgent) error {
+		user, err := tx.DBConn.GetByEmail(ctx, global.NormalizeEmail(req.GetEmail()))
+		if err == global.ErrorUserNotFound {
+			return nil
+		}
+
+		if err != nil {
+			return internalError(err)
+		}
+
+		if reset, err = tx.issueActionToken(ctx, user, models.ActionResetPassword, tx.Auth.PASSWORD_RESET_TTL); err != nil {
+			return internalError(err)
+		}
+
+		return nil
+	})
+
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, err
+	}
+
+	if reset.Token != "" {
+		agent.notifyAsync(reset.User.Email, notify.TemplateResetPassword, reset)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// set new password by reset token; the token works once
+func (agent *UserAgent) ConfirmPasswordReset(ctx context.Context, req *ConfirmPasswordResetRequest) (*emptypb.Empty, error) {
+
+	if req.GetToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, global.ErrorEmptyToken.Error())
+	}
+
+	if !global.ValidatePassword(req.GetNewPassword()) {
+		return nil, status.Error(codes.InvalidArgument, global.ErrorBadPassword.Error())
 	}
-	log.Println(newPass)
-	///TODO: write the handler for sending new pass
-	err = agent.DBConn.SetPassword(req.GetUserId(), newPass)
+
+	// bcrypt is slow, so hash before the transaction
+	hash, err := global.EncodingPassword(req.GetNewPassword())
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, internalError(err)
+	}
+
+	var user models.User
+	err = agent.inTx(ctx, func(tx *UserAgent) error {
+		token, err := tx.TokenConn.UseActionToken(ctx, models.ActionResetPassword, tokens.HashToken(req.GetToken()))
+		switch {
+		case errors.Is(err, global.ErrorTokenNotFound):
+			return status.Error(codes.InvalidArgument, global.ErrorInvalidToken.Error())
+		case errors.Is(err, global.ErrorTokenExpired):
+			return status.Error(codes.FailedPrecondition, global.ErrorTokenExpired.Error())
+		case err != nil:
+			return internalError(err)
+		}
+
+		// owner was deleted after the token was issued
+		user, err = tx.DBConn.GetById(ctx, token.UserId.String())
+		if err == global.ErrorUserNotFound {
+			return status.Error(codes.InvalidArgument, global.ErrorInvalidToken.Error())
+		}
+
+		if err != nil {
+			return internalError(err)
+		}
+
+		if err = tx.DBConn.SetPassword(ctx, user.Id.String(), hash); err != nil {
+			return internalError(err)
+		}
+
+		// token came by email, so the address is proven
+		if !user.EmailVerified {
+			if err = tx.DBConn.SetEmailVerified(ctx, user.Id.String(), true); err != nil {
+				return internalError(err)
+			}
+		}
+
+		// sessions and failed attempts of the old password are not valid anymore
+		if err = tx.TokenConn.RevokeUserRefreshTokens(ctx, user.Id.String()); err != nil {
+			return internalError(err)
+		}
+
+		if err = tx.resetLockout(ctx, user); err != nil {
+			return internalError(err)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
 	}
 
+	agent.notify(ctx, user.Email, notify.TemplatePasswordChanged, notify.Data{User: user})
+
 	return &emptypb.Empty{}, nil
 }
+
+// search active users by name, surname or email; results ordered by relevance,
+// page token is base64 of the offset of the next page
+func (agent *UserAgent) SearchUsers(ctx context.Context, req *SearchUsersRequest) (*SearchUsersResponse, error) {
+
+	if strings.TrimSpace(req.GetQuery()) == "" {
+		return nil, status.Error(codes.InvalidArgument, global.ErrorEmptySearchQuery.Error())
+	}
+
+	limit := defaultPageSize
+	if req.GetPageSize() < 0 {
+		return nil, status.Error(codes.InvalidArgument, global.ErrorInvalidFormat.Error())
+	}
+	if req.GetPageSize() > 0 {
+		limit = int(req.GetPageSize())
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	offset := 0
+	if req.GetPageToken() != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(req.GetPageToken())
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, global.ErrorInvalidPageToken.Error())
+		}
+		offset, err = strconv.Atoi(string(raw))
+		if err != nil || offset < 0 {
+			return nil, status.Error(codes.InvalidArgument, global.ErrorInvalidPageToken.Error())
+		}
+	}
+
+	rows, total, err := agent.DBConn.Search(ctx, req.GetQuery(), limit, offset)
+	if err != nil {
+		return nil, internalError(err)
+	}
+
+	resp := &SearchUsersResponse{TotalCount: total}
+	if next := offset + len(rows); int64(next) < total {
+		resp.NextPageToken = base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(next)))
+	}
+
+	for _, row := range rows {
+		resp.Results = append(resp.Results, &SearchUsersResult{
+			User:  userResponse(row.User),
+			Score: float32(row.Rank),
+		})
+	}
+
+	return resp, nil
+}
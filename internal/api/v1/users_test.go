@@ -0,0 +1,135 @@
+package v1
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang-unitied-school/useragent/config"
+	"github.com/golang-unitied-school/useragent/internal/models"
+	"google.golang.org/grpc/codes"
+)
+
+func TestCreateUserDuplicateMixedCaseEmail(t *testing.T) {
+	agent := newTestAgent(t)
+	createUser(t, agent, "ivan@example.com")
+
+	_, err := agent.CreateUser(context.Background(), &CreateUserRequest{
+		Name:     "Ivan",
+		Surname:  "Petrov",
+		Email:    "  Ivan@EXAMPLE.com ",
+		Password: testPassword,
+	})
+	assertCode(t, err, codes.AlreadyExists)
+}
+
+func TestInvalidUserIdIsInvalidArgument(t *testing.T) {
+	agent := newTestAgent(t)
+
+	_, err := agent.UpdateUser(context.Background(), &UpdateUserRequest{UserId: "nope", Name: "Petr"})
+	assertCode(t, err, codes.InvalidArgument)
+
+	_, err = agent.ChangePassword(context.Background(), &ChangePasswordRequest{UserId: "nope", OldPassword: testPassword, NewPassword: "N3w-passw0rd"})
+	assertCode(t, err, codes.InvalidArgument)
+}
+
+func TestConfirmPasswordResetEndsSessions(t *testing.T) {
+	agent := newTestAgent(t)
+	ctx := context.Background()
+	id := createUser(t, agent, "ivan@example.com")
+	session := login(t, agent, "ivan@example.com")
+
+	_, err := agent.AuthUser(ctx, &AuthUserRequest{Email: "ivan@example.com", Password: "wrong"})
+	assertCode(t, err, codes.Unauthenticated)
+
+	user, err := agent.DBConn.GetById(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reset, err := agent.issueActionToken(ctx, user, models.ActionResetPassword, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = agent.ConfirmPasswordReset(ctx, &ConfirmPasswordResetRequest{Token: reset.Token, NewPassword: "N3w-passw0rd"}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = agent.RefreshToken(ctx, &RefreshTokenRequest{RefreshToken: session.GetRefreshToken()})
+	assertCode(t, err, codes.Unauthenticated)
+
+	if user, err = agent.DBConn.GetById(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if user.FailedLogins != 0 {
+		t.Fatalf("failed logins are not reset: %d", user.FailedLogins)
+	}
+}
+
+func TestRestoreUser(t *testing.T) {
+	agent := newTestAgent(t)
+	ctx := context.Background()
+	id := createUser(t, agent, "ivan@example.com")
+
+	_, err := agent.RestoreUser(ctx, &RestoreUserRequest{UserId: id})
+	assertCode(t, err, codes.FailedPrecondition)
+
+	if _, err = agent.DeleteUser(ctx, &DeleteUserRequest{UserId: id}); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := agent.RestoreUser(ctx, &RestoreUserRequest{UserId: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.GetIsDeleted() != 0 || restored.GetDeletedAt() != nil {
+		t.Fatalf("user is still deleted: %v", restored)
+	}
+}
+
+func TestRestoreUserEmailReused(t *testing.T) {
+	agent := newTestAgent(t)
+	agent.Auth.AUTH_DELETED_EMAIL_POLICY = config.DeletedEmailReuse
+	ctx := context.Background()
+
+	id := createUser(t, agent, "ivan@example.com")
+	if _, err := agent.DeleteUser(ctx, &DeleteUserRequest{UserId: id}); err != nil {
+		t.Fatal(err)
+	}
+	createUser(t, agent, "IVAN@example.com")
+
+	_, err := agent.RestoreUser(ctx, &RestoreUserRequest{UserId: id})
+	assertCode(t, err, codes.AlreadyExists)
+}
+
+func TestRestoreUserWindowExpired(t *testing.T) {
+	agent := newTestAgent(t)
+	agent.Auth.AUTH_RESTORE_WINDOW = time.Nanosecond
+	ctx := context.Background()
+
+	id := createUser(t, agent, "ivan@example.com")
+	if _, err := agent.DeleteUser(ctx, &DeleteUserRequest{UserId: id}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+
+	_, err := agent.RestoreUser(ctx, &RestoreUserRequest{UserId: id})
+	assertCode(t, err, codes.FailedPrecondition)
+}
+
+func TestChangePassword(t *testing.T) {
+	agent := newTestAgent(t)
+	ctx := context.Background()
+	id := createUser(t, agent, "ivan@example.com")
+
+	_, err := agent.ChangePassword(ctx, &ChangePasswordRequest{UserId: id, OldPassword: "Wr0ng-pass", NewPassword: "N3w-passw0rd"})
+	assertCode(t, err, codes.FailedPrecondition)
+
+	if _, err = agent.ChangePassword(ctx, &ChangePasswordRequest{UserId: id, OldPassword: testPassword, NewPassword: "N3w-passw0rd"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = agent.AuthUser(ctx, &AuthUserRequest{Email: "ivan@example.com", Password: "N3w-passw0rd"}); err != nil {
+		t.Fatal(err)
+	}
+}
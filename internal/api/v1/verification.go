@@ -0,0 +1,92 @@
+package v1
+
+import (
+	"context"
+	"errors"
+
+	"github.com/golang-unitied-school/useragent/internal/models"
+	"github.com/golang-unitied-school/useragent/internal/pkg/notify"
+	"github.com/golang-unitied-school/useragent/internal/pkg/tokens"
+	global "github.com/golang-unitied-school/useragent/internal/pkg/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// inner func for issuing verification token; previous tokens of user stop working
+func (agent *UserAgent) issueVerification(ctx context.Context, user models.User) (notify.Data, error) {
+	return agent.issueActionToken(ctx, user, models.ActionVerifyEmail, agent.Auth.EMAIL_VERIFICATION_TTL)
+}
+
+// issue new verification token; unknown and already verified emails are silently ignored
+func (agent *UserAgent) SendVerificationEmail(ctx context.Context, req *SendVerificationEmailRequest) (*emptypb.Empty, error) {
+
+	if !global.CheckEmail(global.NormalizeEmail(req.GetEmail())) {
+		return nil, status.Error(codes.InvalidArgument, global.ErrorInvalidEmailFormat.Error())
+	}
+
+	var verification notify.Data
+	err := agent.inTx(ctx, func(tx *UserAgent) error {
+		user, err := tx.DBConn.GetByEmail(ctx, global.NormalizeEmail(req.GetEmail()))
+		if err == global.ErrorUserNotFound || user.EmailVerified {
+			return nil
+		}
+
+		if err != nil {
+			return internalError(err)
+		}
+
+		if verification, err = tx.issueVerification(ctx, user); err != nil {
+			return internalError(err)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if verification.Token != "" {
+		agent.notifyAsync(verification.User.Email, notify.TemplateVerifyEmail, verification)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// mark email of token owner as verified
+func (agent *UserAgent) VerifyEmail(ctx context.Context, req *VerifyEmailRequest) (*emptypb.Empty, error) {
+
+	if req.GetToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, global.ErrorEmptyToken.Error())
+	}
+
+	err := agent.inTx(ctx, func(tx *UserAgent) error {
+		token, err := tx.TokenConn.UseActionToken(ctx, models.ActionVerifyEmail, tokens.HashToken(req.GetToken()))
+		switch {
+		case errors.Is(err, global.ErrorTokenNotFound):
+			return status.Error(codes.InvalidArgument, global.ErrorInvalidToken.Error())
+		case errors.Is(err, global.ErrorTokenExpired):
+			return status.Error(codes.FailedPrecondition, global.ErrorTokenExpired.Error())
+		case err != nil:
+			return internalError(err)
+		}
+
+		err = tx.DBConn.SetEmailVerified(ctx, token.UserId.String(), true)
+		if err == global.ErrorUserNotFound {
+			return status.Error(codes.NotFound, global.ErrorUserNotFound.Error())
+		}
+
+		if err != nil {
+			return internalError(err)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &emptypb.Empty{}, nil
+}
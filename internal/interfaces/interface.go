@@ -1,19 +1,85 @@
 package interfaces
 
 import (
+	"context"
+	"time"
+
 	"github.com/golang-unitied-school/useragent/internal/models"
+	"github.com/golang-unitied-school/useragent/internal/repositories/migrations"
 )
 
 type EmptyUser struct{}
 
 type UserDataManager interface {
 	Init(connectionString string)
-	Create(user *models.User) (string, error)
-	Update(uuid, fname, sname, email, role string) error
-	Delete(userId string) error
-	GetById(userId string) (models.User, error)
-	GetByEmail(email string) (models.User, error)
-	GetPassword(userId string) (string, error)
-	SetPassword(userId, newPass string) error
+	Create(ctx context.Context, user *models.User) (string, error)
+	Update(ctx context.Context, uuid, fname, sname, email, role string) error
+	// soft delete; actor is id of user who deletes the account
+	Delete(ctx context.Context, userId, actor string) error
+	// undo soft delete; ErrorUserExists if email is taken by active user
+	Restore(ctx context.Context, userId string) error
+	// irreversibly remove soft deleted user row with tokens and recovery codes and store the report;
+	// ErrorUserNotDeleted for active user, actor is id of user who erases the account and as is the ground,
+	// see models.ErasureReport
+	Erase(ctx context.Context, userId, actor, as string) (models.ErasureReport, error)
+	// stored report of erased user; ErrorUserNotFound if user was not erased
+	GetErasureReport(ctx context.Context, userId string) (models.ErasureReport, error)
+	// user, also deleted, with all tokens and recovery codes; rows are ordered by creation time
+	GetUserData(ctx context.Context, userId string) (models.UserData, error)
+	// soft deleted users with deleted_at before the time, oldest first; rows without deleted_at
+	// are skipped, migration 0015 backfills it for users deleted before 0012
+	ListDeletedBefore(ctx context.Context, before time.Time, limit, offset int) ([]models.User, error)
+	// active users only; ErrorUserNotFound for deleted ones
+	GetById(ctx context.Context, userId string) (models.User, error)
+	GetByEmail(ctx context.Context, email string) (models.User, error)
+	// also deleted users; by email the active user goes first, then the most recently created deleted one
+	GetByIdWithDeleted(ctx context.Context, userId string) (models.User, error)
+	GetByEmailWithDeleted(ctx context.Context, email string) (models.User, error)
+	List(ctx context.Context, query models.ListUsersQuery) ([]models.User, int64, error)
+	Search(ctx context.Context, query string, limit, offset int) ([]models.UserMatch, int64, error)
+	GetPassword(ctx context.Context, userId string) (string, error)
+	// store password hash, the caller hashes new password with global.EncodingPassword
+	SetPassword(ctx context.Context, userId, hash string) error
+	SetEmailVerified(ctx context.Context, userId string, verified bool) error
+	SetMFA(ctx context.Context, userId, secret string, enabled bool, lastStep int64) error
+	// store step of accepted TOTP code; ErrorInvalidMFACode if the step is not newer than stored one
+	UseMFAStep(ctx context.Context, userId string, step int64) error
+	// store failed login counters; nil lockedUntil means account is not locked
+	SetLockout(ctx context.Context, userId string, failedLogins, lockouts int32, lockedUntil *time.Time) error
+	// run fn atomically; storage passed to fn must be used for all calls inside it
+	WithTx(ctx context.Context, fn func(tx DataManager) error) error
 	Close() error
 }
+
+type TokenDataManager interface {
+	CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error
+	GetRefreshToken(ctx context.Context, tokenHash string) (models.RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, oldId string, next *models.RefreshToken) error
+	// actor is id of user who revokes the tokens, empty if revoked by the service itself
+	RevokeRefreshFamily(ctx context.Context, familyId, actor string) error
+	RevokeUserRefreshTokens(ctx context.Context, userId string) error
+	RevokeAccessToken(ctx context.Context, token *models.RevokedToken) error
+	IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error)
+	CreateActionToken(ctx context.Context, token *models.ActionToken) error
+	// mark unused token as used; ErrorTokenNotFound if unknown, ErrorTokenExpired if used or expired
+	UseActionToken(ctx context.Context, purpose, tokenHash string) (models.ActionToken, error)
+	// invalidate all unused tokens of user for purpose
+	RevokeActionTokens(ctx context.Context, userId, purpose string) error
+	// delete all recovery codes of user and store new ones
+	ReplaceRecoveryCodes(ctx context.Context, userId string, hashes []string) error
+	// mark unused code as used; ErrorInvalidMFACode if there is no such code
+	UseRecoveryCode(ctx context.Context, userId, hash string) error
+	CountRecoveryCodes(ctx context.Context, userId string) (int64, error)
+}
+
+// nil Migrator means storage has no schema to migrate
+type SchemaManager interface {
+	Migrator() *migrations.Migrator
+}
+
+// whole storage implementation, returned by db initialization
+type DataManager interface {
+	UserDataManager
+	TokenDataManager
+	SchemaManager
+}
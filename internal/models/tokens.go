@@ -0,0 +1,59 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// refresh token row; only sha256 of the token is stored
+// tokens issued from one login share FamilyId; RevokedBy is empty unless revoked by RevokeToken
+type RefreshToken struct {
+	Id        uuid.UUID `gorm:"primarykey;type:uuid;default:public.uuid_generate_v4()"`
+	UserId    uuid.UUID `gorm:"type:uuid;index"`
+	FamilyId  uuid.UUID `gorm:"type:uuid;index"`
+	TokenHash string    `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	RotatedAt *time.Time
+	RevokedAt *time.Time
+	RevokedBy string `gorm:"not null;default:''"`
+}
+
+// revoked access token, identified by jti claim;
+// row may be purged once ExpiresAt passes; RevokedBy is id of user who called RevokeToken
+type RevokedToken struct {
+	Jti       string    `gorm:"primarykey"`
+	UserId    uuid.UUID `gorm:"type:uuid;index"`
+	ExpiresAt time.Time `gorm:"index"`
+	RevokedAt time.Time `gorm:"autoCreateTime"`
+	RevokedBy string    `gorm:"not null;default:''"`
+}
+
+// purposes of action tokens
+const (
+	ActionVerifyEmail   = "verify_email"
+	ActionResetPassword = "reset_password"
+	// issued after password check, exchanged for tokens with TOTP code
+	ActionMFAChallenge = "mfa_challenge"
+)
+
+// single-use token sent to user by email; only sha256 of the token is stored
+type ActionToken struct {
+	Id        uuid.UUID `gorm:"primarykey;type:uuid;default:public.uuid_generate_v4()"`
+	UserId    uuid.UUID `gorm:"type:uuid;index:idx_action_tokens_user_purpose"`
+	Purpose   string    `gorm:"index:idx_action_tokens_user_purpose"`
+	TokenHash string    `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UsedAt    *time.Time
+}
+
+// one-time MFA recovery code; only sha256 of the code is stored
+type RecoveryCode struct {
+	Id        uuid.UUID `gorm:"primarykey;type:uuid;default:public.uuid_generate_v4()"`
+	UserId    uuid.UUID `gorm:"type:uuid;index"`
+	CodeHash  string
+	CreatedAt time.Time
+	UsedAt    *time.Time
+}
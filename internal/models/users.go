@@ -6,13 +6,91 @@ import (
 	"github.com/google/uuid"
 )
 
+// EmailNormalized is the unique identity of user, see utils.NormalizeEmail;
+// TotpSecret is encrypted (see mfa.Manager) and is set with MfaEnabled false while enrollment is not confirmed;
+// FailedLogins counts failures since the last lockout, Lockouts counts lockouts since the last successful login;
+// DeletedBy is id of user who called DeleteUser, empty if unknown
 type User struct {
-	Id        uuid.UUID `gorm:"primarykey;type:uuid;default:public.uuid_generate_v4()"`
-	Name      string
-	Surname   string
-	Email     string `gorm:"index"`
-	Password  string
-	Role      string `gorm:"index;default:user"`
+	Id              uuid.UUID `gorm:"primarykey;type:uuid;default:public.uuid_generate_v4();index:idx_users_created_id,priority:2"`
+	Name            string
+	Surname         string
+	Email           string
+	EmailNormalized string `gorm:"not null;uniqueIndex:idx_users_email_normalized,where:is_deleted = 0"`
+	EmailVerified   bool   `gorm:"not null;default:false"`
+	TotpSecret      string
+	MfaEnabled      bool  `gorm:"not null;default:false"`
+	MfaLastStep     int64 `gorm:"not null;default:0"`
+	FailedLogins    int32 `gorm:"not null;default:0"`
+	Lockouts        int32 `gorm:"not null;default:0"`
+	LockedUntil     *time.Time
+	Password        string
+	Role            string    `gorm:"index;default:user"`
+	CreatedAt       time.Time `gorm:"index:idx_users_created_id,priority:1"`
+	IsDeleted       int32     `gorm:"default:0;index"`
+	DeletedAt       *time.Time
+	DeletedBy       string `gorm:"not null;default:''"`
+}
+
+// roles with access to accounts of other users; service is for other backends,
+// e.g. to log users out
+const (
+	RoleAdmin   = "admin"
+	RoleService = "service"
+)
+
+// what was removed by erasure of one user; has no personal data and is kept
+// after the user is gone. ErasedBy is id of user who called EraseUser, or RetentionActor;
+// ErasedAs is the ground of erasure: ErasedAsOwner, ErasedAsAdmin or RetentionActor
+type ErasureReport struct {
+	UserId        uuid.UUID `gorm:"primarykey;type:uuid"`
+	DeletedAt     *time.Time
+	ErasedAt      time.Time `gorm:"index"`
+	ErasedBy      string    `gorm:"not null;default:''"`
+	ErasedAs      string    `gorm:"not null;default:''"`
+	RefreshTokens int64
+	RevokedTokens int64
+	ActionTokens  int64
+	RecoveryCodes int64
+}
+
+// ErasedBy and ErasedAs of users erased by retention purge
+const RetentionActor = "retention"
+
+// ErasedAs of users erased by EraseUser: the account owner or admin
+const (
+	ErasedAsOwner = "owner"
+	ErasedAsAdmin = RoleAdmin
+)
+
+// everything stored about one user, for personal data export
+type UserData struct {
+	User          User
+	RefreshTokens []RefreshToken
+	RevokedTokens []RevokedToken
+	ActionTokens  []ActionToken
+	RecoveryCodes []RecoveryCode
+}
+
+// position of the last user on the previous page
+type UserCursor struct {
 	CreatedAt time.Time
-	IsDeleted int32 `gorm:"default:0;index"`
+	Id        uuid.UUID
+}
+
+// filters and keyset pagination for users listing;
+// nil IsDeleted means both active and deleted users
+type ListUsersQuery struct {
+	Role        string
+	IsDeleted   *int32
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	Ascending   bool
+	Limit       int
+	After       *UserCursor
+}
+
+// search hit with relevance score, higher is better
+type UserMatch struct {
+	User `gorm:"embedded"`
+	Rank float64
 }
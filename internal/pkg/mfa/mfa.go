@@ -0,0 +1,220 @@
+package mfa
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/golang-unitied-school/useragent/config"
+	global "github.com/golang-unitied-school/useragent/internal/pkg/utils"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	period = 30
+	// accepted clock drift in periods on either side
+	skew = 1
+	// 10 chars of 32-letter alphabet without i, l, o and 1 give 50 bits per recovery code
+	recoveryAlphabet   = "abcdefghjkmnpqrstuvwxyz023456789"
+	recoveryCodeLength = 10
+)
+
+var validateOpts = totp.ValidateOpts{
+	Period:    period,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+// generates and checks TOTP codes (RFC 6238); secrets are stored
+// encrypted with AES-256-GCM
+type Manager struct {
+	aead          cipher.AEAD
+	issuer        string
+	challengeTTL  time.Duration
+	requiredRoles []string
+	recoveryCodes int
+}
+
+// create manager with MFA_ENCRYPTION_KEY (base64 of 32 bytes);
+// without the key MFA is disabled, so it can not be required for any role
+func NewManager(cfg config.MFAConfig) (*Manager, error) {
+	m := &Manager{
+		issuer:        cfg.MFA_ISSUER,
+		challengeTTL:  cfg.MFA_CHALLENGE_TTL,
+		requiredRoles: cfg.MFA_REQUIRED_ROLES,
+		recoveryCodes: int(cfg.MFA_RECOVERY_CODES),
+	}
+	if cfg.MFA_ENCRYPTION_KEY == "" {
+		if len(m.requiredRoles) > 0 {
+			return nil, global.ErrorMFANotConfigured
+		}
+		return m, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(cfg.MFA_ENCRYPTION_KEY)
+	if err != nil || len(key) != 32 {
+		return nil, global.ErrorInvalidMFAKey
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *Manager) Enabled() bool {
+	return m.aead != nil
+}
+
+// whether accounts with role must enable MFA before they can log in
+func (m *Manager) Required(role string) bool {
+	for _, required := range m.requiredRoles {
+		if required == role {
+			return true
+		}
+	}
+	return false
+}
+
+// lifetime of challenge between password check and TOTP code
+func (m *Manager) ChallengeTTL() time.Duration {
+	return m.challengeTTL
+}
+
+// size of recovery code set issued to user
+func (m *Manager) RecoveryCodes() int {
+	return m.recoveryCodes
+}
+
+// new secret for account and otpauth:// URI for QR code
+func (m *Manager) Generate(account string) (string, string, error) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      m.issuer,
+		AccountName: account,
+		Period:      period,
+		Digits:      validateOpts.Digits,
+		Algorithm:   validateOpts.Algorithm,
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return key.Secret(), key.URL(), nil
+}
+
+// base64 of nonce and sealed secret
+func (m *Manager) Encrypt(secret string) (string, error) {
+	if !m.Enabled() {
+		return "", global.ErrorMFANotConfigured
+	}
+
+	nonce := make([]byte, m.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := m.aead.Seal(nonce, nonce, []byte(secret), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (m *Manager) Decrypt(encrypted string) (string, error) {
+	if !m.Enabled() {
+		return "", global.ErrorMFANotConfigured
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil || len(sealed) < m.aead.NonceSize() {
+		return "", global.ErrorInvalidMFASecret
+	}
+
+	nonce, ciphertext := sealed[:m.aead.NonceSize()], sealed[m.aead.NonceSize():]
+	secret, err := m.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", global.ErrorInvalidMFASecret
+	}
+
+	return string(secret), nil
+}
+
+// check code against time steps around now; steps up to lastStep were already
+// used and are rejected. Returns matched step to be stored as the new lastStep
+func (m *Manager) Validate(secret, code string, lastStep int64) (int64, bool) {
+	now := time.Now().Unix()
+
+	for i := -skew; i <= skew; i++ {
+		at := now + int64(i*period)
+		step := at / period
+		if step <= lastStep {
+			continue
+		}
+
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(at, 0), validateOpts)
+		if err != nil {
+			return 0, false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// whether code looks like TOTP code rather than recovery code
+func IsTOTPCode(code string) bool {
+	if len(code) != int(validateOpts.Digits) {
+		return false
+	}
+
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// generate n one-time recovery codes formatted as xxxxx-xxxxx; returns codes for user and hashes for storage
+func NewRecoveryCodes(n int) ([]string, []string, error) {
+	codes := make([]string, 0, n)
+	hashes := make([]string, 0, n)
+
+	for i := 0; i < n; i++ {
+		raw := make([]byte, recoveryCodeLength)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+
+		for j := range raw {
+			raw[j] = recoveryAlphabet[raw[j]&31]
+		}
+
+		code := string(raw[:recoveryCodeLength/2]) + "-" + string(raw[recoveryCodeLength/2:])
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// hash of recovery code; case, spaces and dashes entered by user are ignored
+func HashRecoveryCode(code string) string {
+	normalized := strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
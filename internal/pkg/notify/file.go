@@ -0,0 +1,51 @@
+package notify
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// writes messages to file or stdout for local development
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+	c  io.Closer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// append messages to file, creating it if needed
+func NewFile(path string) (*Writer, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Writer{w: file, c: file}, nil
+}
+
+func (ptr *Writer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ptr.mu.Lock()
+	defer ptr.mu.Unlock()
+
+	raw := strings.ReplaceAll(string(msg.bytes()), "\r\n", "\n")
+	_, err := io.WriteString(ptr.w, raw+"\n")
+	return err
+}
+
+func (ptr *Writer) Close() error {
+	if ptr.c == nil {
+		return nil
+	}
+
+	return ptr.c.Close()
+}
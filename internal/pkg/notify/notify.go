@@ -0,0 +1,192 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"io/fs"
+	"mime"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/golang-unitied-school/useragent/config"
+	"github.com/golang-unitied-school/useragent/internal/models"
+	global "github.com/golang-unitied-school/useragent/internal/pkg/utils"
+)
+
+// account emails; every template file <name>.tmpl defines "subject" and "body"
+const (
+	TemplateVerifyEmail     = "verify_email"
+	TemplateResetPassword   = "reset_password"
+	TemplatePasswordChanged = "password_changed"
+	TemplateEmailChanged    = "email_changed"
+)
+
+var templateNames = []string{
+	TemplateVerifyEmail,
+	TemplateResetPassword,
+	TemplatePasswordChanged,
+	TemplateEmailChanged,
+}
+
+//go:embed templates/*.tmpl
+var defaultTemplates embed.FS
+
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Body    string
+}
+
+// delivery driver
+type Notifier interface {
+	Send(ctx context.Context, msg Message) error
+	Close() error
+}
+
+// values available in templates; BaseURL is filled by Mailer
+type Data struct {
+	User      models.User
+	Token     string
+	NewEmail  string
+	ExpiresAt time.Time
+	BaseURL   string
+}
+
+// renders account emails from templates and sends them with configured driver
+type Mailer struct {
+	notifier  Notifier
+	templates map[string]*template.Template
+	from      string
+	baseURL   string
+	pending   sync.WaitGroup
+}
+
+// create mailer with driver from NOTIFY_DRIVER: smtp, file or stdout (default);
+// templates are loaded from NOTIFY_TEMPLATES_DIR if set, embedded ones otherwise
+func New(cfg config.NotifyConfig) (*Mailer, error) {
+	var (
+		notifier Notifier
+		err      error
+	)
+
+	switch cfg.NOTIFY_DRIVER {
+	case "smtp":
+		notifier = NewSMTP(cfg.SMTP_HOST, cfg.SMTP_PORT, cfg.SMTP_USER, cfg.SMTP_PASS)
+	case "file":
+		notifier, err = NewFile(cfg.NOTIFY_FILE)
+	case "stdout", "":
+		notifier = NewWriter(os.Stdout)
+	default:
+		return nil, global.ErrorUnknownNotifyDriver
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	source, _ := fs.Sub(defaultTemplates, "templates")
+	if cfg.NOTIFY_TEMPLATES_DIR != "" {
+		source = os.DirFS(cfg.NOTIFY_TEMPLATES_DIR)
+	}
+
+	templates, err := loadTemplates(source)
+	if err != nil {
+		notifier.Close()
+		return nil, err
+	}
+
+	return &Mailer{
+		notifier:  notifier,
+		templates: templates,
+		from:      cfg.NOTIFY_FROM,
+		baseURL:   strings.TrimSuffix(cfg.NOTIFY_BASE_URL, "/"),
+	}, nil
+}
+
+func loadTemplates(source fs.FS) (map[string]*template.Template, error) {
+	templates := make(map[string]*template.Template, len(templateNames))
+	for _, name := range templateNames {
+		tmpl, err := template.ParseFS(source, name+".tmpl")
+		if err != nil {
+			return nil, err
+		}
+
+		if tmpl.Lookup("subject") == nil || tmpl.Lookup("body") == nil {
+			return nil, global.ErrorInvalidTemplate
+		}
+
+		templates[name] = tmpl
+	}
+
+	return templates, nil
+}
+
+// render template and send it to address
+func (m *Mailer) Send(ctx context.Context, to, name string, data Data) error {
+	tmpl, ok := m.templates[name]
+	if !ok {
+		return global.ErrorInvalidTemplate
+	}
+
+	data.BaseURL = m.baseURL
+
+	var subject, body bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return err
+	}
+	if err := tmpl.ExecuteTemplate(&body, "body", data); err != nil {
+		return err
+	}
+
+	return m.notifier.Send(ctx, Message{
+		From:    m.from,
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Body:    strings.TrimSpace(body.String()) + "\n",
+	})
+}
+
+// send in background, so response time does not depend on delivery;
+// done gets the result of Send
+func (m *Mailer) SendAsync(to, name string, data Data, done func(error)) {
+	m.pending.Add(1)
+	go func() {
+		defer m.pending.Done()
+		done(m.Send(context.Background(), to, name, data))
+	}()
+}
+
+// wait for background sends and close driver
+func (m *Mailer) Close() error {
+	m.pending.Wait()
+	return m.notifier.Close()
+}
+
+// message in RFC 5322 format with CRLF line endings
+func (msg Message) bytes() []byte {
+	var buf bytes.Buffer
+
+	// line breaks in values would inject headers
+	header := func(key, value string) {
+		value = strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+		buf.WriteString(key + ": " + value + "\r\n")
+	}
+
+	if msg.From != "" {
+		header("From", msg.From)
+	}
+	header("To", msg.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "8bit")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	return buf.Bytes()
+}
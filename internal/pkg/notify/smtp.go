@@ -0,0 +1,93 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/mail"
+	"net/smtp"
+)
+
+// port of SMTP over implicit TLS; other ports use STARTTLS when server offers it
+const smtpsPort = "465"
+
+// sends messages through SMTP relay, one connection per message
+type SMTP struct {
+	host string
+	port string
+	user string
+	pass string
+}
+
+func NewSMTP(host, port, user, pass string) *SMTP {
+	return &SMTP{host: host, port: port, user: user, pass: pass}
+}
+
+func (ptr *SMTP) Send(ctx context.Context, msg Message) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ptr.host, ptr.port))
+	if err != nil {
+		return err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+
+	tlsConfig := &tls.Config{ServerName: ptr.host, MinVersion: tls.VersionTLS12}
+	if ptr.port == smtpsPort {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	client, err := smtp.NewClient(conn, ptr.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err = client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+
+	if ptr.user != "" {
+		if err = client.Auth(smtp.PlainAuth("", ptr.user, ptr.pass, ptr.host)); err != nil {
+			return err
+		}
+	}
+
+	// envelope sender is bare address of "Name <address>"
+	from := msg.From
+	if address, err := mail.ParseAddress(msg.From); err == nil {
+		from = address.Address
+	}
+
+	if err = client.Mail(from); err != nil {
+		return err
+	}
+	if err = client.Rcpt(msg.To); err != nil {
+		return err
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg.bytes()); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+func (ptr *SMTP) Close() error {
+	return nil
+}
@@ -0,0 +1,184 @@
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	global "github.com/golang-unitied-school/useragent/internal/pkg/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// response header with seconds until the next attempt is allowed
+const RetryAfterKey = "retry-after"
+
+// bucket keys
+const (
+	KeyPeer  = "peer"
+	KeyEmail = "email"
+)
+
+// Burst requests per Period; zero Burst means no limit
+type Limit struct {
+	Burst  int
+	Period time.Duration
+}
+
+// tokens per second
+func (l Limit) rate() float64 {
+	return float64(l.Burst) / l.Period.Seconds()
+}
+
+// limits of one method by client address and by email from request
+type Rule struct {
+	Peer  Limit
+	Email Limit
+}
+
+// request with target account, e.g. AuthUserRequest or GetUserByEmailRequest
+type emailRequest interface {
+	GetEmail() string
+}
+
+// token bucket limits for unary gRPC calls
+type Limiter struct {
+	store Store
+	rules map[string]Rule
+}
+
+// rules are keyed by short method name, e.g. AuthUser
+func NewLimiter(store Store, rules map[string]Rule) *Limiter {
+	return &Limiter{store: store, rules: rules}
+}
+
+// parse rules like "AuthUser:peer:20/1m,AuthUser:email:5/1m"; "off" disables all limits
+func ParseRules(spec string) (map[string]Rule, error) {
+	rules := make(map[string]Rule)
+	if strings.TrimSpace(spec) == "off" {
+		return rules, nil
+	}
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.Split(item, ":")
+		if len(parts) != 3 {
+			return nil, global.ErrorInvalidRateLimit
+		}
+
+		limit, err := parseLimit(parts[2])
+		if err != nil {
+			return nil, err
+		}
+
+		rule := rules[parts[0]]
+		switch parts[1] {
+		case KeyPeer:
+			rule.Peer = limit
+		case KeyEmail:
+			rule.Email = limit
+		default:
+			return nil, global.ErrorInvalidRateLimit
+		}
+		rules[parts[0]] = rule
+	}
+
+	return rules, nil
+}
+
+// "<burst>/<period>", e.g. 5/1m
+func parseLimit(raw string) (Limit, error) {
+	burst, period, ok := strings.Cut(raw, "/")
+	if !ok {
+		return Limit{}, global.ErrorInvalidRateLimit
+	}
+
+	n, err := strconv.Atoi(burst)
+	if err != nil || n <= 0 {
+		return Limit{}, global.ErrorInvalidRateLimit
+	}
+
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Limit{}, global.ErrorInvalidRateLimit
+	}
+
+	return Limit{Burst: n, Period: d}, nil
+}
+
+func (l *Limiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		method := info.FullMethod[strings.LastIndex(info.FullMethod, "/")+1:]
+		rule, ok := l.rules[method]
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		if rule.Peer.Burst > 0 {
+			if err := l.allow(ctx, method+":"+KeyPeer+":"+clientAddress(ctx), rule.Peer); err != nil {
+				return nil, err
+			}
+		}
+
+		if r, ok := req.(emailRequest); ok && rule.Email.Burst > 0 && r.GetEmail() != "" {
+			if err := l.allow(ctx, method+":"+KeyEmail+":"+global.NormalizeEmail(r.GetEmail()), rule.Email); err != nil {
+				return nil, err
+			}
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// store failures do not block requests
+func (l *Limiter) allow(ctx context.Context, key string, limit Limit) error {
+	ok, wait, err := l.store.Allow(ctx, key, limit)
+	if err != nil {
+		log.Printf("error while checking rate limit: %s", err.Error())
+		return nil
+	}
+
+	if ok {
+		return nil
+	}
+
+	seconds := strconv.Itoa(int(math.Ceil(wait.Seconds())))
+	if err = grpc.SetHeader(ctx, metadata.Pairs(RetryAfterKey, seconds)); err != nil {
+		log.Printf("error while setting %s header: %s", RetryAfterKey, err.Error())
+	}
+
+	return status.Error(codes.ResourceExhausted, global.ErrorRateLimited.Error())
+}
+
+// IP of TCP peer; calls over other transports come from local REST gateway,
+// so the last X-Forwarded-For entry added by it is used
+func clientAddress(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	if addr, ok := p.Addr.(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("x-forwarded-for"); len(values) > 0 {
+			forwarded := strings.Split(values[len(values)-1], ",")
+			return strings.TrimSpace(forwarded[len(forwarded)-1])
+		}
+	}
+
+	return p.Addr.String()
+}
@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// buckets not touched for this long are checked for removal
+const sweepInterval = time.Minute
+
+// token bucket storage; a shared implementation (e.g. Redis) makes limits
+// common for all replicas
+type Store interface {
+	// take one token from bucket key; if the bucket is empty reports false
+	// and time until the next token
+	Allow(ctx context.Context, key string, limit Limit) (bool, time.Duration, error)
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   Limit
+}
+
+// refill bucket up to burst by time passed since the last update
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*b.limit.rate())
+	b.updated = now
+}
+
+// store of single replica; full buckets are dropped periodically
+type Memory struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{buckets: make(map[string]*bucket), lastSweep: time.Now()}
+}
+
+func (ptr *Memory) Allow(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	if err := ctx.Err(); err != nil {
+		return false, 0, err
+	}
+
+	ptr.mu.Lock()
+	defer ptr.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(ptr.lastSweep) > sweepInterval {
+		ptr.sweep(now)
+	}
+
+	b, ok := ptr.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		ptr.buckets[key] = b
+	}
+	b.limit = limit
+	b.refill(now)
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / limit.rate() * float64(time.Second))
+		return false, wait, nil
+	}
+
+	b.tokens--
+	return true, 0, nil
+}
+
+// full bucket is the same as missing one
+func (ptr *Memory) sweep(now time.Time) {
+	for key, b := range ptr.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Burst) {
+			delete(ptr.buckets, key)
+		}
+	}
+	ptr.lastSweep = now
+}
@@ -0,0 +1,127 @@
+package retention
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/golang-unitied-school/useragent/config"
+	"github.com/golang-unitied-school/useragent/internal/models"
+	global "github.com/golang-unitied-school/useragent/internal/pkg/utils"
+)
+
+// users erased in one storage call
+const batchSize = 100
+
+// part of repository used by purge
+type Storage interface {
+	ListDeletedBefore(ctx context.Context, before time.Time, limit, offset int) ([]models.User, error)
+	Erase(ctx context.Context, userId, actor, as string) (models.ErasureReport, error)
+}
+
+// result of one purge; in dry run Users lists candidates that were not erased,
+// so only their ids and deletion times are set
+type Report struct {
+	DryRun bool
+	Before time.Time
+	Users  []models.ErasureReport
+}
+
+// erases users soft deleted more than RETENTION_PERIOD ago
+type Job struct {
+	storage  Storage
+	period   time.Duration
+	interval time.Duration
+	dryRun   bool
+}
+
+func NewJob(storage Storage, cfg config.RetentionConfig) *Job {
+	return &Job{
+		storage:  storage,
+		period:   cfg.RETENTION_PERIOD,
+		interval: cfg.RETENTION_INTERVAL,
+		dryRun:   cfg.RETENTION_DRY_RUN,
+	}
+}
+
+// purge on start and then every RETENTION_INTERVAL until ctx is done
+func (j *Job) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := j.Purge(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("error while purging deleted users: %s", err.Error())
+		}
+		Log(report)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// erase users deleted before now minus RETENTION_PERIOD; users with unknown
+// deletion time are included. Report has users erased before an error
+func (j *Job) Purge(ctx context.Context) (Report, error) {
+	report := Report{DryRun: j.dryRun, Before: time.Now().Add(-j.period)}
+
+	for offset := 0; ; {
+		rows, err := j.storage.ListDeletedBefore(ctx, report.Before, batchSize, offset)
+		if err != nil {
+			return report, err
+		}
+
+		for _, row := range rows {
+			if j.dryRun {
+				report.Users = append(report.Users, models.ErasureReport{UserId: row.Id, DeletedAt: row.DeletedAt})
+				continue
+			}
+
+			erased, err := j.storage.Erase(ctx, row.Id.String(), models.RetentionActor, models.RetentionActor)
+			if err == global.ErrorUserNotFound || err == global.ErrorUserNotDeleted {
+				// erased or restored by another replica
+				continue
+			}
+			if err != nil {
+				return report, err
+			}
+			report.Users = append(report.Users, erased)
+		}
+
+		if len(rows) < batchSize {
+			return report, nil
+		}
+
+		// erased rows leave the list, in dry run they stay
+		if j.dryRun {
+			offset += len(rows)
+		}
+	}
+}
+
+// write erasure report to log, one line per user
+func Log(report Report) {
+	action := "erased"
+	if report.DryRun {
+		action = "would erase (dry run)"
+	}
+
+	for _, user := range report.Users {
+		deletedAt := "unknown"
+		if user.DeletedAt != nil {
+			deletedAt = user.DeletedAt.UTC().Format(time.RFC3339)
+		}
+		if report.DryRun {
+			log.Printf("retention: %s user %s deleted at %s", action, user.UserId, deletedAt)
+			continue
+		}
+		log.Printf("retention: %s user %s deleted at %s: refresh tokens %d, revoked tokens %d, action tokens %d, recovery codes %d",
+			action, user.UserId, deletedAt, user.RefreshTokens, user.RevokedTokens, user.ActionTokens, user.RecoveryCodes)
+	}
+
+	log.Printf("retention: %s %d users deleted before %s", action, len(report.Users), report.Before.UTC().Format(time.RFC3339))
+}
@@ -0,0 +1,165 @@
+package tokens
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	global "github.com/golang-unitied-school/useragent/internal/pkg/utils"
+)
+
+const keyFileExt = ".pem"
+
+// private key used for signing, identified by kid
+type signingKey struct {
+	kid    string
+	signer crypto.Signer
+	method jwt.SigningMethod
+	// zero while key is active or still present in key directory
+	retiredAt time.Time
+}
+
+// public key in JWK format (RFC 7517)
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+func newSigningKey(kid string, signer crypto.Signer) (*signingKey, error) {
+	method, err := signingMethod(signer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &signingKey{kid: kid, signer: signer, method: method}, nil
+}
+
+// generate Ed25519 key for local development; kid is derived from public key
+func ephemeralKey() (*signingKey, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(pub)
+	return newSigningKey(hex.EncodeToString(sum[:8]), priv)
+}
+
+// load key from PEM file; kid is the file name without extension
+func loadKeyFile(path string) (*signingKey, error) {
+	signer, err := loadKey(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSigningKey(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)), signer)
+}
+
+// load all *.pem keys from directory, sorted by kid
+func loadKeyDir(dir string) ([]*signingKey, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*signingKey
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != keyFileExt {
+			continue
+		}
+
+		key, err := loadKeyFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, global.ErrorNoSigningKeys
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].kid < keys[j].kid })
+	return keys, nil
+}
+
+func loadKey(path string) (crypto.Signer, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, global.ErrorInvalidKey
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, global.ErrorInvalidKey
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	return nil, global.ErrorInvalidKey
+}
+
+func signingMethod(key crypto.Signer) (jwt.SigningMethod, error) {
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		return jwt.SigningMethodRS256, nil
+	case ed25519.PrivateKey:
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, global.ErrorInvalidKey
+	}
+}
+
+// public part of key in JWK format
+func (k *signingKey) jwk() JSONWebKey {
+	key := JSONWebKey{
+		Use: "sig",
+		Alg: k.method.Alg(),
+		Kid: k.kid,
+	}
+
+	switch pub := k.signer.Public().(type) {
+	case *rsa.PublicKey:
+		key.Kty = "RSA"
+		key.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		key.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		key.Kty = "OKP"
+		key.Crv = "Ed25519"
+		key.X = base64.RawURLEncoding.EncodeToString(pub)
+	}
+
+	return key
+}
@@ -0,0 +1,260 @@
+package tokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/golang-unitied-school/useragent/config"
+	"github.com/golang-unitied-school/useragent/internal/models"
+	global "github.com/golang-unitied-school/useragent/internal/pkg/utils"
+	"github.com/google/uuid"
+)
+
+const (
+	TokenType         = "Bearer"
+	opaqueTokenLength = 32
+)
+
+// payload of access token
+type Claims struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+// signs access tokens for authenticated users;
+// keeps retired keys published until tokens signed by them expire
+type Manager struct {
+	mu         sync.RWMutex
+	active     *signingKey
+	keys       map[string]*signingKey
+	keysDir    string
+	activeKid  string
+	issuer     string
+	ttl        time.Duration
+	refreshTTL time.Duration
+}
+
+// create manager with keys from JWT_KEYS_DIR or single JWT_PRIVATE_KEY file (PEM, PKCS#8 or PKCS#1);
+// if neither is set, ephemeral Ed25519 key will be generated
+func NewManager(cfg config.TokenConfig) (*Manager, error) {
+	m := &Manager{
+		keys:       make(map[string]*signingKey),
+		keysDir:    cfg.JWT_KEYS_DIR,
+		activeKid:  cfg.JWT_ACTIVE_KID,
+		issuer:     cfg.JWT_ISSUER,
+		ttl:        cfg.JWT_ACCESS_TTL,
+		refreshTTL: cfg.JWT_REFRESH_TTL,
+	}
+
+	var (
+		key *signingKey
+		err error
+	)
+
+	switch {
+	case m.keysDir != "":
+		return m, m.Reload()
+	case cfg.JWT_PRIVATE_KEY != "":
+		key, err = loadKeyFile(cfg.JWT_PRIVATE_KEY)
+	default:
+		log.Println("no JWT private key configured, generating ephemeral key..")
+		key, err = ephemeralKey()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m.active = key
+	m.keys[key.kid] = key
+	return m, nil
+}
+
+// rescan key directory and switch active key if needed;
+// keys removed from directory stay published for access token ttl
+func (m *Manager) Reload() error {
+	if m.keysDir == "" {
+		return nil
+	}
+
+	loaded, err := loadKeyDir(m.keysDir)
+	if err != nil {
+		return err
+	}
+
+	active := loaded[len(loaded)-1]
+	if m.activeKid != "" {
+		active = nil
+		for _, key := range loaded {
+			if key.kid == m.activeKid {
+				active = key
+			}
+		}
+		if active == nil {
+			return global.ErrorActiveKeyNotFound
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	next := make(map[string]*signingKey, len(loaded))
+	for _, key := range loaded {
+		next[key.kid] = key
+	}
+
+	for kid, key := range m.keys {
+		if _, ok := next[kid]; ok {
+			continue
+		}
+		if key.retiredAt.IsZero() {
+			key.retiredAt = now
+		}
+		if now.Before(key.retiredAt.Add(m.ttl)) {
+			next[kid] = key
+		}
+	}
+
+	if m.active != nil && m.active.kid != active.kid {
+		log.Printf("signing key rotated: %s -> %s", m.active.kid, active.kid)
+	}
+
+	m.active = active
+	m.keys = next
+	return nil
+}
+
+// public keys for token verification by other services
+func (m *Manager) JWKS() JSONWebKeySet {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	set := JSONWebKeySet{Keys: make([]JSONWebKey, 0, len(m.keys))}
+	for _, key := range m.keys {
+		set.Keys = append(set.Keys, key.jwk())
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
+
+// serve key set at /.well-known/jwks.json
+func (m *Manager) JWKSHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		if err := json.NewEncoder(w).Encode(m.JWKS()); err != nil {
+			log.Printf("error while writing jwks: %s", err.Error())
+		}
+	})
+}
+
+// issue signed access token for user
+func (m *Manager) Issue(user models.User) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(m.ttl)
+
+	claims := Claims{
+		Email: user.Email,
+		Role:  user.Role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Issuer:    m.issuer,
+			Subject:   user.Id.String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	m.mu.RLock()
+	key := m.active
+	m.mu.RUnlock()
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+
+	signed, err := token.SignedString(key.signer)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return signed, expiresAt, nil
+}
+
+// verify signature and registered claims of access token
+func (m *Manager) Parse(token string) (*Claims, error) {
+	var claims Claims
+
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{
+		jwt.SigningMethodRS256.Alg(),
+		jwt.SigningMethodEdDSA.Alg(),
+	}))
+	_, err := parser.ParseWithClaims(token, &claims, m.verificationKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if !claims.VerifyIssuer(m.issuer, m.issuer != "") {
+		return nil, global.ErrorInvalidToken
+	}
+
+	return &claims, nil
+}
+
+// generate opaque refresh token; returns the token for client and its hash for storage
+func (m *Manager) NewRefreshToken() (string, string, time.Time, error) {
+	token, hash, err := NewOpaqueToken()
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+
+	return token, hash, time.Now().Add(m.refreshTTL), nil
+}
+
+// generate random url-safe token and its hash for storage
+func NewOpaqueToken() (string, string, error) {
+	raw := make([]byte, opaqueTokenLength)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	return token, HashToken(token), nil
+}
+
+// sha256 of opaque token, only hashes are stored
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// pick published key by kid from token header
+func (m *Manager) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	m.mu.RLock()
+	key, ok := m.keys[kid]
+	m.mu.RUnlock()
+
+	if !ok || key.method.Alg() != token.Method.Alg() {
+		return nil, global.ErrorInvalidToken
+	}
+
+	return key.signer.Public(), nil
+}
@@ -3,31 +3,87 @@ package utils
 import (
 	"errors"
 	"regexp"
+	"strings"
 	"unicode"
 
 	"github.com/badoux/checkmail"
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/net/idna"
 )
 
 const minEntropy = 42
 
 var (
-	ErrorRecordNotFound     = errors.New("record not found")
-	ErrorEmptyLogin         = errors.New("you must have email for login")
-	ErrorEmptyCredentials   = errors.New("you must have name and surname")
-	ErrorEmptyPass          = errors.New("you must have password for you account")
-	ErrorUserExists         = errors.New("user already exists")
-	ErrorUserNotFound       = errors.New("user not found")
-	ErrorUnauthenticated    = errors.New("login or password is uncorrect")
-	ErrorOldPassInvalid     = errors.New("old pass isn`t valid")
-	ErrorInvalidFormat      = errors.New("invalid format of input string")
-	ErrorInvalidEmailFormat = errors.New("invalid email format")
-	ErrorPasswordNotMatched = errors.New("bad old password")
-	ErrorNewOldPassMatched  = errors.New("new and old password musn`t be matched")
-	ErrorBadPassword        = errors.New("password must have 8 chars, at least one uppercase, one lowercase letter, one number and one special char")
-	ErrorNoNewData          = errors.New("no data for update")
+	ErrorRecordNotFound        = errors.New("record not found")
+	ErrorEmptyLogin            = errors.New("you must have email for login")
+	ErrorEmptyCredentials      = errors.New("you must have name and surname")
+	ErrorEmptyPass             = errors.New("you must have password for you account")
+	ErrorUserExists            = errors.New("user already exists")
+	ErrorUserNotFound          = errors.New("user not found")
+	ErrorUnauthenticated       = errors.New("login or password is uncorrect")
+	ErrorOldPassInvalid        = errors.New("old pass isn`t valid")
+	ErrorInvalidFormat         = errors.New("invalid format of input string")
+	ErrorInvalidEmailFormat    = errors.New("invalid email format")
+	ErrorPasswordNotMatched    = errors.New("bad old password")
+	ErrorNewOldPassMatched     = errors.New("new and old password musn`t be matched")
+	ErrorBadPassword           = errors.New("password must have 8 chars, at least one uppercase, one lowercase letter, one number and one special char")
+	ErrorNoNewData             = errors.New("no data for update")
+	ErrorEmptySearchQuery      = errors.New("you must have search query")
+	ErrorInvalidPageToken      = errors.New("invalid page token")
+	ErrorTokenNotFound         = errors.New("token not found")
+	ErrorTokenReused           = errors.New("refresh token was already used")
+	ErrorTokenExpired          = errors.New("token expired or revoked")
+	ErrorInvalidToken          = errors.New("invalid token")
+	ErrorEmptyToken            = errors.New("you must have token")
+	ErrorNoSigningKeys         = errors.New("no signing keys found in key directory")
+	ErrorActiveKeyNotFound     = errors.New("active signing key not found in key directory")
+	ErrorInvalidMigration      = errors.New("migration file name must be <version>_<name>.up.sql or .down.sql")
+	ErrorUnknownMigration      = errors.New("unknown migration version")
+	ErrorIrreversibleMigration = errors.New("migration has no down script")
+	ErrorSchemaOutdated        = errors.New("database schema is out of date, run migrate up")
+	ErrorInvalidKey            = errors.New("unsupported or malformed signing key, expected RSA or Ed25519 in PEM")
+	ErrorEmailNotVerified      = errors.New("email is not verified")
+	ErrorUnknownNotifyDriver   = errors.New("unknown notify driver, expected smtp, file or stdout")
+	ErrorInvalidTemplate       = errors.New("email template must define subject and body")
+	ErrorInvalidMFAKey         = errors.New("MFA_ENCRYPTION_KEY must be base64 of 32 bytes")
+	ErrorMFANotConfigured      = errors.New("multi-factor authentication is not configured")
+	ErrorInvalidMFASecret      = errors.New("stored TOTP secret can not be decrypted")
+	ErrorMFAAlreadyEnabled     = errors.New("multi-factor authentication is already enabled")
+	ErrorMFANotEnabled         = errors.New("multi-factor authentication is not enabled")
+	ErrorMFANotEnrolled        = errors.New("multi-factor enrollment is not started")
+	ErrorMFARequired           = errors.New("multi-factor authentication must be enabled for this account")
+	ErrorInvalidMFACode        = errors.New("invalid or already used code")
+	ErrorAccountLocked         = errors.New("account is temporarily locked after failed logins")
+	ErrorUserNotDeleted        = errors.New("user is not deleted")
+	ErrorRestoreWindowExpired  = errors.New("restore window of deleted user has expired")
+	ErrorEmailReused           = errors.New("email of deleted user is used by another account")
+	ErrorRateLimited           = errors.New("too many requests, retry later")
+	ErrorInvalidRateLimit      = errors.New("invalid rate limit rule, expected <method>:<peer|email>:<burst>/<period>")
+	ErrorMigrationConflicts    = errors.New("migration check found conflicting rows, resolve them and retry")
+	ErrorAuthRequired          = errors.New("authorization bearer token is required")
+	ErrorNotTokenOwner         = errors.New("token belongs to another user")
+	ErrorPermissionDenied      = errors.New("account belongs to another user")
+	ErrorAdminRequired         = errors.New("operation is allowed only to admin")
 )
 
+// canonical form of email used as identity: trimmed, lowercased,
+// internationalized domain converted to punycode
+func NormalizeEmail(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+
+	domain, err := idna.Lookup.ToASCII(email[at+1:])
+	if err != nil {
+		return email
+	}
+
+	return email[:at+1] + domain
+}
+
 func CheckEmail(input string) bool {
 	if err := checkmail.ValidateFormat(input); err != nil {
 		return false
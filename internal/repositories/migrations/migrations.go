@@ -0,0 +1,346 @@
+package migrations
+
+import (
+	"database/sql"
+	"embed"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	global "github.com/golang-unitied-school/useragent/internal/pkg/utils"
+	"gorm.io/gorm"
+)
+
+// numbered migrations per dialect: <version>_<name>.up.sql and <version>_<name>.down.sql;
+// optional <version>_<name>.check.sql is a query run before up, any row it returns aborts the migration
+//
+//go:embed postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+const (
+	upSuffix    = ".up.sql"
+	downSuffix  = ".down.sql"
+	checkSuffix = ".check.sql"
+)
+
+const createTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version bigint PRIMARY KEY,
+	name text NOT NULL,
+	applied_at timestamp NOT NULL
+)`
+
+type Migration struct {
+	Version uint
+	Name    string
+	Up      string
+	Down    string
+	Check   string
+}
+
+// migration with time it was applied; nil AppliedAt means pending
+type Status struct {
+	Version   uint
+	Name      string
+	AppliedAt *time.Time
+}
+
+// applied migration row
+type schemaMigration struct {
+	Version   uint
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+// create migrator with embedded migrations for dialect (directory name)
+func New(db *gorm.DB, dialect string) (*Migrator, error) {
+	migrations, err := load(dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+func load(dialect string) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[uint]*Migration)
+	for _, entry := range entries {
+		name := entry.Name()
+
+		var suffix string
+		switch {
+		case strings.HasSuffix(name, upSuffix):
+			suffix = upSuffix
+		case strings.HasSuffix(name, downSuffix):
+			suffix = downSuffix
+		case strings.HasSuffix(name, checkSuffix):
+			suffix = checkSuffix
+		default:
+			continue
+		}
+
+		prefix, title, found := strings.Cut(strings.TrimSuffix(name, suffix), "_")
+		version, err := strconv.ParseUint(prefix, 10, 32)
+		if !found || err != nil {
+			return nil, global.ErrorInvalidMigration
+		}
+
+		body, err := fs.ReadFile(files, path.Join(dialect, name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[uint(version)]
+		if !ok {
+			m = &Migration{Version: uint(version), Name: title}
+			byVersion[uint(version)] = m
+		}
+
+		switch suffix {
+		case upSuffix:
+			m.Up = string(body)
+		case downSuffix:
+			m.Down = string(body)
+		case checkSuffix:
+			m.Check = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, global.ErrorInvalidMigration
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+func (m *Migrator) applied() (map[uint]time.Time, error) {
+	if err := m.db.Exec(createTable).Error; err != nil {
+		return nil, err
+	}
+
+	var rows []schemaMigration
+	if err := m.db.Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	applied := make(map[uint]time.Time, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row.AppliedAt
+	}
+
+	return applied, nil
+}
+
+func (m *Migrator) apply(migration Migration) error {
+	log.Printf("applying migration %04d_%s..", migration.Version, migration.Name)
+
+	return m.db.Transaction(func(tx *gorm.DB) error {
+		if err := check(tx, migration); err != nil {
+			return err
+		}
+
+		if err := tx.Exec(migration.Up).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&schemaMigration{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			AppliedAt: time.Now().UTC(),
+		}).Error
+	})
+}
+
+// run check query of migration and log every conflicting row it reports
+func check(tx *gorm.DB, migration Migration) error {
+	if migration.Check == "" {
+		return nil
+	}
+
+	rows, err := tx.Raw(migration.Check).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	conflicts := 0
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+
+		if err = rows.Scan(dest...); err != nil {
+			return err
+		}
+
+		fields := make([]string, len(columns))
+		for i, column := range columns {
+			fields[i] = column + "=" + values[i].String
+		}
+
+		log.Printf("conflict in migration %04d_%s: %s", migration.Version, migration.Name, strings.Join(fields, " "))
+		conflicts++
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if conflicts == 0 {
+		return nil
+	}
+
+	return global.ErrorMigrationConflicts
+}
+
+func (m *Migrator) revert(migration Migration) error {
+	log.Printf("reverting migration %04d_%s..", migration.Version, migration.Name)
+
+	if migration.Down == "" {
+		return global.ErrorIrreversibleMigration
+	}
+
+	return m.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(migration.Down).Error; err != nil {
+			return err
+		}
+
+		return tx.Where("version = ?", migration.Version).Delete(&schemaMigration{}).Error
+	})
+}
+
+// apply all pending migrations
+func (m *Migrator) Up() error {
+	if len(m.migrations) == 0 {
+		return nil
+	}
+
+	return m.To(m.migrations[len(m.migrations)-1].Version)
+}
+
+// revert the latest applied migration
+func (m *Migrator) Down() error {
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		if _, ok := applied[m.migrations[i].Version]; ok {
+			return m.revert(m.migrations[i])
+		}
+	}
+
+	return nil
+}
+
+// apply or revert migrations until schema is at version; 0 reverts everything
+func (m *Migrator) To(version uint) error {
+	if version != 0 && !m.known(version) {
+		return global.ErrorUnknownMigration
+	}
+
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; ok && migration.Version > version {
+			if err = m.revert(migration); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; !ok && migration.Version <= version {
+			if err = m.apply(migration); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *Migrator) known(version uint) bool {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return true
+		}
+	}
+
+	return false
+}
+
+// state of every known migration
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := Status{Version: migration.Version, Name: migration.Name}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// returns ErrorSchemaOutdated if any migration is pending
+func (m *Migrator) Check() error {
+	statuses, err := m.Status()
+	if err != nil {
+		return err
+	}
+
+	pending := 0
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			log.Printf("pending migration %04d_%s", status.Version, status.Name)
+			pending++
+		}
+	}
+
+	if pending > 0 {
+		return global.ErrorSchemaOutdated
+	}
+
+	return nil
+}
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_users_name_trgm;
+DROP INDEX IF EXISTS idx_users_surname_trgm;
+DROP INDEX IF EXISTS idx_users_email_trgm;
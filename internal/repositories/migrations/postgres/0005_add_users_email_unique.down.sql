@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_users_email_unique;
+CREATE INDEX IF NOT EXISTS idx_users_email ON users (email);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_users_email;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_unique ON users (email);
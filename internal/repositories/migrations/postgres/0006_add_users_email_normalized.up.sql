@@ -0,0 +1,8 @@
+-- application also converts internationalized domains to punycode,
+-- stored emails already passed ASCII-only format check
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_normalized text;
+UPDATE users SET email_normalized = lower(trim(email));
+ALTER TABLE users ALTER COLUMN email_normalized SET NOT NULL;
+
+DROP INDEX IF EXISTS idx_users_email_unique;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_normalized ON users (email_normalized);
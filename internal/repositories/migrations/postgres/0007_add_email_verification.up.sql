@@ -0,0 +1,16 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified boolean NOT NULL DEFAULT false;
+-- accounts created before verification was introduced are trusted
+UPDATE users SET email_verified = true;
+
+CREATE TABLE IF NOT EXISTS action_tokens (
+    id uuid PRIMARY KEY DEFAULT public.uuid_generate_v4(),
+    user_id uuid,
+    purpose text,
+    token_hash text,
+    expires_at timestamptz,
+    created_at timestamptz,
+    used_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_action_tokens_user_purpose ON action_tokens (user_id, purpose);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_action_tokens_token_hash ON action_tokens (token_hash);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id uuid PRIMARY KEY DEFAULT public.uuid_generate_v4(),
+    user_id uuid,
+    code_hash text,
+    created_at timestamptz,
+    used_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes (user_id);
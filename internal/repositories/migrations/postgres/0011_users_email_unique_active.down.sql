@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_users_email_normalized;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_normalized ON users (email_normalized);
@@ -0,0 +1,3 @@
+-- users deleted before this migration have no deleted_at and can not be restored
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_by text NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE refresh_tokens DROP COLUMN revoked_by;
+ALTER TABLE revoked_tokens DROP COLUMN revoked_by;
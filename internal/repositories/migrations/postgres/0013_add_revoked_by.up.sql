@@ -0,0 +1,3 @@
+-- who called RevokeToken; empty for tokens revoked by the service itself
+ALTER TABLE revoked_tokens ADD COLUMN IF NOT EXISTS revoked_by text NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS revoked_by text NOT NULL DEFAULT '';
@@ -0,0 +1,13 @@
+-- audit of erased users; rows hold no personal data and outlive the user
+CREATE TABLE IF NOT EXISTS erasure_reports (
+    user_id uuid PRIMARY KEY,
+    deleted_at timestamptz,
+    erased_at timestamptz,
+    erased_by text NOT NULL DEFAULT '',
+    refresh_tokens bigint NOT NULL DEFAULT 0,
+    revoked_tokens bigint NOT NULL DEFAULT 0,
+    action_tokens bigint NOT NULL DEFAULT 0,
+    recovery_codes bigint NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_erasure_reports_erased_at ON erasure_reports (erased_at);
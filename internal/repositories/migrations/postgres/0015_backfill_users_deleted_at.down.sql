@@ -0,0 +1,2 @@
+-- backfilled deleted_at can not be told from a real one, so it is kept
+SELECT 1;
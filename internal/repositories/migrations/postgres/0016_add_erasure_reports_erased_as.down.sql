@@ -0,0 +1 @@
+ALTER TABLE erasure_reports DROP COLUMN erased_as;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS users (
+    id text PRIMARY KEY,
+    name text,
+    surname text,
+    email text,
+    password text,
+    role text DEFAULT 'user',
+    created_at datetime,
+    is_deleted integer DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_users_email ON users (email);
+CREATE INDEX IF NOT EXISTS idx_users_role ON users (role);
+CREATE INDEX IF NOT EXISTS idx_users_is_deleted ON users (is_deleted);
+CREATE INDEX IF NOT EXISTS idx_users_created_id ON users (created_at, id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_users_name_lower;
+DROP INDEX IF EXISTS idx_users_surname_lower;
+DROP INDEX IF EXISTS idx_users_email_lower;
@@ -0,0 +1,5 @@
+-- SQLite has no pg_trgm: fuzzy ranking is done by the application,
+-- these indexes only serve prefix lookups
+CREATE INDEX IF NOT EXISTS idx_users_name_lower ON users (lower(name));
+CREATE INDEX IF NOT EXISTS idx_users_surname_lower ON users (lower(surname));
+CREATE INDEX IF NOT EXISTS idx_users_email_lower ON users (lower(email));
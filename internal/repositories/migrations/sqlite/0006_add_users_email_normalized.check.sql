@@ -0,0 +1,6 @@
+-- emails that become equal after normalization must be merged or renamed before upgrade
+SELECT lower(trim(email)) AS email_normalized, count(*) AS users
+FROM users
+GROUP BY lower(trim(email))
+HAVING count(*) > 1
+ORDER BY email_normalized
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_users_email_normalized;
+ALTER TABLE users DROP COLUMN email_normalized;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_unique ON users (email);
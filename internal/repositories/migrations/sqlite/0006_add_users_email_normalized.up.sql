@@ -0,0 +1,7 @@
+-- application also converts internationalized domains to punycode,
+-- stored emails already passed ASCII-only format check
+ALTER TABLE users ADD COLUMN email_normalized text NOT NULL DEFAULT '';
+UPDATE users SET email_normalized = lower(trim(email));
+
+DROP INDEX IF EXISTS idx_users_email_unique;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_normalized ON users (email_normalized);
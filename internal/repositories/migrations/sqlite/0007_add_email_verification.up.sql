@@ -0,0 +1,16 @@
+ALTER TABLE users ADD COLUMN email_verified boolean NOT NULL DEFAULT false;
+-- accounts created before verification was introduced are trusted
+UPDATE users SET email_verified = true;
+
+CREATE TABLE IF NOT EXISTS action_tokens (
+    id text PRIMARY KEY,
+    user_id text,
+    purpose text,
+    token_hash text,
+    expires_at datetime,
+    created_at datetime,
+    used_at datetime
+);
+
+CREATE INDEX IF NOT EXISTS idx_action_tokens_user_purpose ON action_tokens (user_id, purpose);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_action_tokens_token_hash ON action_tokens (token_hash);
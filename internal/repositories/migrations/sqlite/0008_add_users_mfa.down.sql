@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN mfa_last_step;
+ALTER TABLE users DROP COLUMN mfa_enabled;
+ALTER TABLE users DROP COLUMN totp_secret;
@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN totp_secret text NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN mfa_enabled boolean NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN mfa_last_step bigint NOT NULL DEFAULT 0;
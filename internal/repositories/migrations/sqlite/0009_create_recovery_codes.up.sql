@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id text PRIMARY KEY,
+    user_id text,
+    code_hash text,
+    created_at datetime,
+    used_at datetime
+);
+
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes (user_id);
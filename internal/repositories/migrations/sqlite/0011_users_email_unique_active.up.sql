@@ -0,0 +1,3 @@
+-- deleted accounts do not hold their email, see AUTH_DELETED_EMAIL_POLICY
+DROP INDEX IF EXISTS idx_users_email_normalized;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_normalized ON users (email_normalized) WHERE is_deleted = 0;
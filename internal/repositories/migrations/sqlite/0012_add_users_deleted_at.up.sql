@@ -0,0 +1,3 @@
+-- users deleted before this migration have no deleted_at and can not be restored
+ALTER TABLE users ADD COLUMN deleted_at datetime;
+ALTER TABLE users ADD COLUMN deleted_by text NOT NULL DEFAULT '';
@@ -0,0 +1,13 @@
+-- audit of erased users; rows hold no personal data and outlive the user
+CREATE TABLE IF NOT EXISTS erasure_reports (
+    user_id text PRIMARY KEY,
+    deleted_at datetime,
+    erased_at datetime,
+    erased_by text NOT NULL DEFAULT '',
+    refresh_tokens integer NOT NULL DEFAULT 0,
+    revoked_tokens integer NOT NULL DEFAULT 0,
+    action_tokens integer NOT NULL DEFAULT 0,
+    recovery_codes integer NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_erasure_reports_erased_at ON erasure_reports (erased_at);
@@ -0,0 +1,3 @@
+-- users deleted before 0012 have no deleted_at; count their retention and restore window
+-- from now instead of erasing them at the first purge
+UPDATE users SET deleted_at = strftime('%Y-%m-%d %H:%M:%f+00:00', 'now') WHERE is_deleted = 1 AND deleted_at IS NULL;
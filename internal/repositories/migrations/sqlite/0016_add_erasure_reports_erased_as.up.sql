@@ -0,0 +1,2 @@
+-- ground of erasure: owner, admin or retention; empty for reports stored before
+ALTER TABLE erasure_reports ADD COLUMN erased_as text NOT NULL DEFAULT '';
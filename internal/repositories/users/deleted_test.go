@@ -0,0 +1,70 @@
+package users
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-unitied-school/useragent/internal/models"
+	"github.com/google/uuid"
+)
+
+// users deleted before 0012 have no deleted_at; they must not be erased at once
+func TestListDeletedBeforeLegacyRow(t *testing.T) {
+	ctx := context.Background()
+	later := time.Now().Add(time.Hour)
+
+	lite := new(SQLite)
+	lite.Init(filepath.Join(t.TempDir(), "useragent.db"))
+	t.Cleanup(func() { lite.Close() })
+	if err := lite.Migrator().To(14); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := newUser(ctx, lite, "ivan@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = lite.dbConn.Exec("UPDATE users SET is_deleted = 1, deleted_at = NULL WHERE id = ?", id).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := lite.ListDeletedBefore(ctx, later, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 0 {
+		t.Fatalf("legacy row without deleted_at is listed: %+v", rows)
+	}
+
+	migrated := time.Now()
+	if err = lite.Migrator().Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	// backfilled deletion time is the migration time
+	rows, err = lite.ListDeletedBefore(ctx, migrated.Add(-time.Minute), 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 0 {
+		t.Fatalf("backfilled row is listed before migration time: %+v", rows)
+	}
+
+	rows, err = lite.ListDeletedBefore(ctx, later, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].DeletedAt == nil {
+		t.Fatalf("backfilled row is not listed: %+v", rows)
+	}
+
+	memory := new(Memory)
+	memory.Init("")
+	memory.users[uuid.MustParse(id)] = models.User{Id: uuid.MustParse(id), Email: "ivan@example.com", IsDeleted: 1}
+
+	if rows, err = memory.ListDeletedBefore(ctx, later, 10, 0); err != nil || len(rows) != 0 {
+		t.Fatalf("Memory: legacy row without deleted_at is listed: %+v, %v", rows, err)
+	}
+}
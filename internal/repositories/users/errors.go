@@ -0,0 +1,26 @@
+package users
+
+import (
+	"errors"
+
+	"github.com/jackc/pgconn"
+	"github.com/mattn/go-sqlite3"
+)
+
+// postgres unique_violation
+const pgUniqueViolation = "23505"
+
+// inner func for detecting duplicate key errors of any supported driver
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == pgUniqueViolation
+	}
+
+	var liteErr sqlite3.Error
+	if errors.As(err, &liteErr) {
+		return liteErr.ExtendedCode == sqlite3.ErrConstraintUnique || liteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+
+	return false
+}
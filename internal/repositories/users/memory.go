@@ -0,0 +1,1049 @@
+package users
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/golang-unitied-school/useragent/internal/interfaces"
+	"github.com/golang-unitied-school/useragent/internal/models"
+	global "github.com/golang-unitied-school/useragent/internal/pkg/utils"
+	"github.com/golang-unitied-school/useragent/internal/repositories/migrations"
+	"github.com/google/uuid"
+)
+
+// same threshold as pg_trgm.similarity_threshold default
+const similarityThreshold = 0.3
+
+// in-memory storage for tests and local development; data is lost on Close
+type Memory struct {
+	mu            *sync.RWMutex
+	users         map[uuid.UUID]models.User
+	refreshTokens map[uuid.UUID]models.RefreshToken
+	revokedTokens map[string]models.RevokedToken
+	actionTokens  map[uuid.UUID]models.ActionToken
+	recoveryCodes map[uuid.UUID]models.RecoveryCode
+	erasures      map[uuid.UUID]models.ErasureReport
+	// set for storage handed to WithTx callback, which already holds the lock
+	inTx bool
+}
+
+func (ptr *Memory) Init(connectionString string) {
+	ptr.mu = new(sync.RWMutex)
+	ptr.users = make(map[uuid.UUID]models.User)
+	ptr.refreshTokens = make(map[uuid.UUID]models.RefreshToken)
+	ptr.revokedTokens = make(map[string]models.RevokedToken)
+	ptr.actionTokens = make(map[uuid.UUID]models.ActionToken)
+	ptr.recoveryCodes = make(map[uuid.UUID]models.RecoveryCode)
+	ptr.erasures = make(map[uuid.UUID]models.ErasureReport)
+}
+
+func (ptr *Memory) lock() {
+	if !ptr.inTx {
+		ptr.mu.Lock()
+	}
+}
+
+func (ptr *Memory) unlock() {
+	if !ptr.inTx {
+		ptr.mu.Unlock()
+	}
+}
+
+func (ptr *Memory) rlock() {
+	if !ptr.inTx {
+		ptr.mu.RLock()
+	}
+}
+
+func (ptr *Memory) runlock() {
+	if !ptr.inTx {
+		ptr.mu.RUnlock()
+	}
+}
+
+// run fn under exclusive lock; on error all maps are restored from snapshot
+func (ptr *Memory) WithTx(ctx context.Context, fn func(tx interfaces.DataManager) error) error {
+	if ptr.inTx {
+		return fn(ptr)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ptr.mu.Lock()
+	defer ptr.mu.Unlock()
+
+	users := copyMap(ptr.users)
+	refreshTokens := copyMap(ptr.refreshTokens)
+	revokedTokens := copyMap(ptr.revokedTokens)
+	actionTokens := copyMap(ptr.actionTokens)
+	recoveryCodes := copyMap(ptr.recoveryCodes)
+	erasures := copyMap(ptr.erasures)
+
+	tx := &Memory{
+		mu:            ptr.mu,
+		users:         ptr.users,
+		refreshTokens: ptr.refreshTokens,
+		revokedTokens: ptr.revokedTokens,
+		actionTokens:  ptr.actionTokens,
+		recoveryCodes: ptr.recoveryCodes,
+		erasures:      ptr.erasures,
+		inTx:          true,
+	}
+
+	if err := fn(tx); err != nil {
+		ptr.users, ptr.refreshTokens, ptr.revokedTokens = users, refreshTokens, revokedTokens
+		ptr.actionTokens, ptr.recoveryCodes, ptr.erasures = actionTokens, recoveryCodes, erasures
+		return err
+	}
+
+	return nil
+}
+
+func copyMap[K comparable, V any](src map[K]V) map[K]V {
+	dst := make(map[K]V, len(src))
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
+
+// inner func for duplicate email check, like unique index on active users; caller must hold the lock
+func (ptr *Memory) emailTaken(email string, except uuid.UUID) bool {
+	normalized := global.NormalizeEmail(email)
+	for _, row := range ptr.users {
+		if row.EmailNormalized == normalized && row.IsDeleted == 0 && row.Id != except {
+			return true
+		}
+	}
+	return false
+}
+
+// memory storage has no schema
+func (ptr *Memory) Migrator() *migrations.Migrator {
+	return nil
+}
+
+func (ptr *Memory) Create(ctx context.Context, user *models.User) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	ptr.lock()
+	defer ptr.unlock()
+
+	if user.Id == uuid.Nil {
+		user.Id = uuid.New()
+	}
+	if user.Role == "" {
+		user.Role = "user"
+	}
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = time.Now()
+	}
+
+	if ptr.emailTaken(user.Email, user.Id) {
+		return "", global.ErrorUserExists
+	}
+	user.EmailNormalized = global.NormalizeEmail(user.Email)
+
+	ptr.users[user.Id] = *user
+	return user.Id.String(), nil
+}
+
+// inner func for lookup of active user; caller must hold the lock
+func (ptr *Memory) activeUser(userId string) (models.User, bool) {
+	id, err := uuid.Parse(userId)
+	if err != nil {
+		return models.User{}, false
+	}
+
+	row, ok := ptr.users[id]
+	if !ok || row.IsDeleted != 0 {
+		return models.User{}, false
+	}
+
+	return row, true
+}
+
+func (ptr *Memory) Update(ctx context.Context, uuid, fname, sname, email, role string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ptr.lock()
+	defer ptr.unlock()
+
+	row, ok := ptr.activeUser(uuid)
+	if !ok {
+		return global.ErrorRecordNotFound
+	}
+
+	changes := 0
+
+	if !strings.EqualFold(row.Name, fname) && fname != "" {
+		row.Name = fname
+		changes++
+	}
+
+	if !strings.EqualFold(row.Surname, sname) && sname != "" {
+		row.Surname = sname
+		changes++
+	}
+
+	if !strings.EqualFold(row.Email, email) && email != "" {
+		if ptr.emailTaken(email, row.Id) {
+			return global.ErrorUserExists
+		}
+		row.Email = email
+		row.EmailNormalized = global.NormalizeEmail(email)
+		changes++
+	}
+
+	if !strings.EqualFold(row.Role, role) && role != "" {
+		row.Role = role
+		changes++
+	}
+
+	if changes == 0 {
+		return global.ErrorNoNewData
+	}
+
+	ptr.users[row.Id] = row
+	return nil
+}
+
+func (ptr *Memory) Delete(ctx context.Context, userId, actor string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ptr.lock()
+	defer ptr.unlock()
+
+	if row, ok := ptr.activeUser(userId); ok {
+		now := time.Now()
+		row.IsDeleted, row.DeletedAt, row.DeletedBy = 1, &now, actor
+		ptr.users[row.Id] = row
+	}
+
+	return nil
+}
+
+func (ptr *Memory) Restore(ctx context.Context, userId string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ptr.lock()
+	defer ptr.unlock()
+
+	id, err := uuid.Parse(userId)
+	if err != nil {
+		return global.ErrorUserNotFound
+	}
+
+	row, ok := ptr.users[id]
+	if !ok || row.IsDeleted == 0 {
+		return global.ErrorUserNotFound
+	}
+
+	if ptr.emailTaken(row.Email, row.Id) {
+		return global.ErrorUserExists
+	}
+
+	row.IsDeleted, row.DeletedAt, row.DeletedBy = 0, nil, ""
+	ptr.users[id] = row
+	return nil
+}
+
+func (ptr *Memory) Erase(ctx context.Context, userId, actor, as string) (models.ErasureReport, error) {
+	if err := ctx.Err(); err != nil {
+		return models.ErasureReport{}, err
+	}
+
+	ptr.lock()
+	defer ptr.unlock()
+
+	id, err := uuid.Parse(userId)
+	if err != nil {
+		return models.ErasureReport{}, global.ErrorUserNotFound
+	}
+
+	row, ok := ptr.users[id]
+	if !ok {
+		return models.ErasureReport{}, global.ErrorUserNotFound
+	}
+
+	if row.IsDeleted == 0 {
+		return models.ErasureReport{}, global.ErrorUserNotDeleted
+	}
+
+	report := models.ErasureReport{UserId: id, DeletedAt: row.DeletedAt, ErasedBy: actor, ErasedAs: as}
+	report.RefreshTokens = deleteByUser(ptr.refreshTokens, id, func(t models.RefreshToken) uuid.UUID { return t.UserId })
+	report.RevokedTokens = deleteByUser(ptr.revokedTokens, id, func(t models.RevokedToken) uuid.UUID { return t.UserId })
+	report.ActionTokens = deleteByUser(ptr.actionTokens, id, func(t models.ActionToken) uuid.UUID { return t.UserId })
+	report.RecoveryCodes = deleteByUser(ptr.recoveryCodes, id, func(c models.RecoveryCode) uuid.UUID { return c.UserId })
+	delete(ptr.users, id)
+
+	report.ErasedAt = time.Now()
+	ptr.erasures[id] = report
+	return report, nil
+}
+
+func (ptr *Memory) GetErasureReport(ctx context.Context, userId string) (models.ErasureReport, error) {
+	if err := ctx.Err(); err != nil {
+		return models.ErasureReport{}, err
+	}
+
+	ptr.rlock()
+	defer ptr.runlock()
+
+	id, err := uuid.Parse(userId)
+	if err != nil {
+		return models.ErasureReport{}, global.ErrorUserNotFound
+	}
+
+	report, ok := ptr.erasures[id]
+	if !ok {
+		return report, global.ErrorUserNotFound
+	}
+
+	return report, nil
+}
+
+// inner func for removing rows of user from table; returns number of removed rows
+func deleteByUser[K comparable, V any](rows map[K]V, userId uuid.UUID, owner func(V) uuid.UUID) int64 {
+	var count int64
+	for key, row := range rows {
+		if owner(row) == userId {
+			delete(rows, key)
+			count++
+		}
+	}
+	return count
+}
+
+func (ptr *Memory) GetUserData(ctx context.Context, userId string) (models.UserData, error) {
+	if err := ctx.Err(); err != nil {
+		return models.UserData{}, err
+	}
+
+	ptr.rlock()
+	defer ptr.runlock()
+
+	id, err := uuid.Parse(userId)
+	if err != nil {
+		return models.UserData{}, global.ErrorUserNotFound
+	}
+
+	user, ok := ptr.users[id]
+	if !ok {
+		return models.UserData{}, global.ErrorUserNotFound
+	}
+
+	data := models.UserData{User: user}
+	for _, row := range ptr.refreshTokens {
+		if row.UserId == id {
+			data.RefreshTokens = append(data.RefreshTokens, row)
+		}
+	}
+	for _, row := range ptr.revokedTokens {
+		if row.UserId == id {
+			data.RevokedTokens = append(data.RevokedTokens, row)
+		}
+	}
+	for _, row := range ptr.actionTokens {
+		if row.UserId == id {
+			data.ActionTokens = append(data.ActionTokens, row)
+		}
+	}
+	for _, row := range ptr.recoveryCodes {
+		if row.UserId == id {
+			data.RecoveryCodes = append(data.RecoveryCodes, row)
+		}
+	}
+
+	// PGSQL order
+	sort.Slice(data.RefreshTokens, func(i, j int) bool {
+		return data.RefreshTokens[i].CreatedAt.Before(data.RefreshTokens[j].CreatedAt)
+	})
+	sort.Slice(data.RevokedTokens, func(i, j int) bool {
+		return data.RevokedTokens[i].RevokedAt.Before(data.RevokedTokens[j].RevokedAt)
+	})
+	sort.Slice(data.ActionTokens, func(i, j int) bool {
+		return data.ActionTokens[i].CreatedAt.Before(data.ActionTokens[j].CreatedAt)
+	})
+	sort.Slice(data.RecoveryCodes, func(i, j int) bool {
+		return data.RecoveryCodes[i].CreatedAt.Before(data.RecoveryCodes[j].CreatedAt)
+	})
+
+	return data, nil
+}
+
+func (ptr *Memory) ListDeletedBefore(ctx context.Context, before time.Time, limit, offset int) ([]models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ptr.rlock()
+	defer ptr.runlock()
+
+	var rows []models.User
+	for _, row := range ptr.users {
+		if row.IsDeleted == 1 && row.DeletedAt != nil && row.DeletedAt.Before(before) {
+			rows = append(rows, row)
+		}
+	}
+
+	// PGSQL order: oldest first
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if !a.DeletedAt.Equal(*b.DeletedAt) {
+			return a.DeletedAt.Before(*b.DeletedAt)
+		}
+		return a.Id.String() < b.Id.String()
+	})
+
+	if offset >= len(rows) {
+		return nil, nil
+	}
+	rows = rows[offset:]
+	if limit < len(rows) {
+		rows = rows[:limit]
+	}
+
+	return rows, nil
+}
+
+func (ptr *Memory) GetById(ctx context.Context, userId string) (models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return models.User{}, err
+	}
+
+	ptr.rlock()
+	defer ptr.runlock()
+
+	row, ok := ptr.activeUser(userId)
+	if !ok {
+		return row, global.ErrorUserNotFound
+	}
+
+	return row, nil
+}
+
+func (ptr *Memory) GetByEmail(ctx context.Context, email string) (models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return models.User{}, err
+	}
+
+	ptr.rlock()
+	defer ptr.runlock()
+
+	found, ok := ptr.userByEmail(email)
+	if !ok || found.IsDeleted != 0 {
+		return models.User{}, global.ErrorUserNotFound
+	}
+
+	return found, nil
+}
+
+func (ptr *Memory) GetByIdWithDeleted(ctx context.Context, userId string) (models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return models.User{}, err
+	}
+
+	ptr.rlock()
+	defer ptr.runlock()
+
+	id, err := uuid.Parse(userId)
+	if err != nil {
+		return models.User{}, global.ErrorUserNotFound
+	}
+
+	row, ok := ptr.users[id]
+	if !ok {
+		return row, global.ErrorUserNotFound
+	}
+
+	return row, nil
+}
+
+func (ptr *Memory) GetByEmailWithDeleted(ctx context.Context, email string) (models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return models.User{}, err
+	}
+
+	ptr.rlock()
+	defer ptr.runlock()
+
+	found, ok := ptr.userByEmail(email)
+	if !ok {
+		return found, global.ErrorUserNotFound
+	}
+
+	return found, nil
+}
+
+// inner func for lookup by email in PGSQL order: active user first, then the
+// most recently created deleted one; caller must hold the lock
+func (ptr *Memory) userByEmail(email string) (models.User, bool) {
+	var (
+		found      models.User
+		ok         bool
+		normalized = global.NormalizeEmail(email)
+	)
+
+	for _, row := range ptr.users {
+		if row.EmailNormalized != normalized {
+			continue
+		}
+		if !ok || row.IsDeleted < found.IsDeleted ||
+			(row.IsDeleted == found.IsDeleted && row.CreatedAt.After(found.CreatedAt)) {
+			found, ok = row, true
+		}
+	}
+
+	return found, ok
+}
+
+func (ptr *Memory) List(ctx context.Context, query models.ListUsersQuery) ([]models.User, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	ptr.rlock()
+	defer ptr.runlock()
+
+	var filtered []models.User
+	for _, row := range ptr.users {
+		if query.Role != "" && row.Role != query.Role {
+			continue
+		}
+		if query.IsDeleted != nil && row.IsDeleted != *query.IsDeleted {
+			continue
+		}
+		if !query.CreatedFrom.IsZero() && row.CreatedAt.Before(query.CreatedFrom) {
+			continue
+		}
+		if !query.CreatedTo.IsZero() && !row.CreatedAt.Before(query.CreatedTo) {
+			continue
+		}
+		filtered = append(filtered, row)
+	}
+
+	sort.Slice(filtered, func(i, j int) bool {
+		return cursorLess(filtered[i], filtered[j]) == query.Ascending
+	})
+
+	page := make([]models.User, 0, query.Limit)
+	for _, row := range filtered {
+		if len(page) == query.Limit {
+			break
+		}
+		if query.After != nil {
+			after := models.User{Id: query.After.Id, CreatedAt: query.After.CreatedAt}
+			if cursorLess(after, row) != query.Ascending || after.Id == row.Id {
+				continue
+			}
+		}
+		page = append(page, row)
+	}
+
+	return page, int64(len(filtered)), nil
+}
+
+// order of (created_at, id), as in idx_users_created_id
+func cursorLess(a, b models.User) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.Id.String() < b.Id.String()
+}
+
+func (ptr *Memory) Search(ctx context.Context, query string, limit, offset int) ([]models.UserMatch, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	ptr.rlock()
+	defer ptr.runlock()
+
+	active := make([]models.User, 0, len(ptr.users))
+	for _, row := range ptr.users {
+		if row.IsDeleted == 0 {
+			active = append(active, row)
+		}
+	}
+
+	matches, total := rankUsers(active, query, limit, offset)
+	return matches, total, nil
+}
+
+// same ranking as PGSQL: prefix match adds 1 to the best trigram similarity;
+// returns requested page and total number of matches
+func rankUsers(rows []models.User, query string, limit, offset int) ([]models.UserMatch, int64) {
+	q := strings.ToLower(strings.TrimSpace(query))
+
+	var matches []models.UserMatch
+	for _, row := range rows {
+		var (
+			rank   float64
+			prefix bool
+		)
+		for _, field := range []string{row.Name, row.Surname, row.Email} {
+			field = strings.ToLower(field)
+			if strings.HasPrefix(field, q) {
+				prefix = true
+			}
+			if sim := similarity(field, q); sim > rank {
+				rank = sim
+			}
+		}
+
+		if !prefix && rank < similarityThreshold {
+			continue
+		}
+		if prefix {
+			rank++
+		}
+
+		matches = append(matches, models.UserMatch{User: row, Rank: rank})
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Rank != matches[j].Rank {
+			return matches[i].Rank > matches[j].Rank
+		}
+		return matches[i].Id.String() < matches[j].Id.String()
+	})
+
+	total := int64(len(matches))
+	if offset >= len(matches) {
+		return nil, total
+	}
+
+	matches = matches[offset:]
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	return matches, total
+}
+
+// trigram similarity as computed by pg_trgm
+func similarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+
+	common := 0
+	for t := range ta {
+		if _, ok := tb[t]; ok {
+			common++
+		}
+	}
+
+	return float64(common) / float64(len(ta)+len(tb)-common)
+}
+
+func trigrams(s string) map[string]struct{} {
+	set := make(map[string]struct{})
+
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			set[string(padded[i:i+3])] = struct{}{}
+		}
+	}
+
+	return set
+}
+
+func (ptr *Memory) GetPassword(ctx context.Context, userId string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	ptr.rlock()
+	defer ptr.runlock()
+
+	row, ok := ptr.activeUser(userId)
+	if !ok {
+		return "", global.ErrorRecordNotFound
+	}
+
+	return row.Password, nil
+}
+
+func (ptr *Memory) SetPassword(ctx context.Context, userId, hash string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ptr.lock()
+	defer ptr.unlock()
+
+	row, ok := ptr.activeUser(userId)
+	if !ok {
+		return global.ErrorRecordNotFound
+	}
+
+	row.Password = hash
+	ptr.users[row.Id] = row
+	return nil
+}
+
+func (ptr *Memory) SetEmailVerified(ctx context.Context, userId string, verified bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ptr.lock()
+	defer ptr.unlock()
+
+	row, ok := ptr.activeUser(userId)
+	if !ok {
+		return global.ErrorUserNotFound
+	}
+
+	row.EmailVerified = verified
+	ptr.users[row.Id] = row
+	return nil
+}
+
+func (ptr *Memory) SetMFA(ctx context.Context, userId, secret string, enabled bool, lastStep int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ptr.lock()
+	defer ptr.unlock()
+
+	row, ok := ptr.activeUser(userId)
+	if !ok {
+		return global.ErrorUserNotFound
+	}
+
+	row.TotpSecret, row.MfaEnabled, row.MfaLastStep = secret, enabled, lastStep
+	ptr.users[row.Id] = row
+	return nil
+}
+
+func (ptr *Memory) SetLockout(ctx context.Context, userId string, failedLogins, lockouts int32, lockedUntil *time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ptr.lock()
+	defer ptr.unlock()
+
+	row, ok := ptr.activeUser(userId)
+	if !ok {
+		return global.ErrorUserNotFound
+	}
+
+	row.FailedLogins, row.Lockouts, row.LockedUntil = failedLogins, lockouts, lockedUntil
+	ptr.users[row.Id] = row
+	return nil
+}
+
+func (ptr *Memory) UseMFAStep(ctx context.Context, userId string, step int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ptr.lock()
+	defer ptr.unlock()
+
+	row, ok := ptr.activeUser(userId)
+	if !ok || row.MfaLastStep >= step {
+		return global.ErrorInvalidMFACode
+	}
+
+	row.MfaLastStep = step
+	ptr.users[row.Id] = row
+	return nil
+}
+
+func (ptr *Memory) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ptr.lock()
+	defer ptr.unlock()
+
+	if token.Id == uuid.Nil {
+		token.Id = uuid.New()
+	}
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now()
+	}
+
+	ptr.refreshTokens[token.Id] = *token
+	return nil
+}
+
+func (ptr *Memory) GetRefreshToken(ctx context.Context, tokenHash string) (models.RefreshToken, error) {
+	if err := ctx.Err(); err != nil {
+		return models.RefreshToken{}, err
+	}
+
+	ptr.rlock()
+	defer ptr.runlock()
+
+	for _, row := range ptr.refreshTokens {
+		if row.TokenHash == tokenHash {
+			return row, nil
+		}
+	}
+
+	return models.RefreshToken{}, global.ErrorTokenNotFound
+}
+
+func (ptr *Memory) RotateRefreshToken(ctx context.Context, oldId string, next *models.RefreshToken) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	id, err := uuid.Parse(oldId)
+	if err != nil {
+		return err
+	}
+
+	ptr.lock()
+	defer ptr.unlock()
+
+	old, ok := ptr.refreshTokens[id]
+	if !ok || old.RotatedAt != nil || old.RevokedAt != nil {
+		return global.ErrorTokenReused
+	}
+
+	now := time.Now()
+	old.RotatedAt = &now
+	ptr.refreshTokens[id] = old
+
+	if next.CreatedAt.IsZero() {
+		next.CreatedAt = now
+	}
+	ptr.refreshTokens[next.Id] = *next
+	return nil
+}
+
+func (ptr *Memory) RevokeUserRefreshTokens(ctx context.Context, userId string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ptr.lock()
+	defer ptr.unlock()
+
+	now := time.Now()
+	for key, row := range ptr.refreshTokens {
+		if row.UserId.String() == userId && row.RevokedAt == nil {
+			row.RevokedAt = &now
+			ptr.refreshTokens[key] = row
+		}
+	}
+
+	return nil
+}
+
+func (ptr *Memory) RevokeRefreshFamily(ctx context.Context, familyId, actor string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	id, err := uuid.Parse(familyId)
+	if err != nil {
+		return err
+	}
+
+	ptr.lock()
+	defer ptr.unlock()
+
+	now := time.Now()
+	for key, row := range ptr.refreshTokens {
+		if row.FamilyId == id && row.RevokedAt == nil {
+			row.RevokedAt, row.RevokedBy = &now, actor
+			ptr.refreshTokens[key] = row
+		}
+	}
+
+	return nil
+}
+
+func (ptr *Memory) RevokeAccessToken(ctx context.Context, token *models.RevokedToken) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ptr.lock()
+	defer ptr.unlock()
+
+	if _, ok := ptr.revokedTokens[token.Jti]; ok {
+		return nil
+	}
+
+	if token.RevokedAt.IsZero() {
+		token.RevokedAt = time.Now()
+	}
+	ptr.revokedTokens[token.Jti] = *token
+	return nil
+}
+
+func (ptr *Memory) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	ptr.rlock()
+	defer ptr.runlock()
+
+	_, ok := ptr.revokedTokens[jti]
+	return ok, nil
+}
+
+func (ptr *Memory) Close() error {
+	return nil
+}
+
+func (ptr *Memory) CreateActionToken(ctx context.Context, token *models.ActionToken) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ptr.lock()
+	defer ptr.unlock()
+
+	if token.Id == uuid.Nil {
+		token.Id = uuid.New()
+	}
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now()
+	}
+
+	ptr.actionTokens[token.Id] = *token
+	return nil
+}
+
+func (ptr *Memory) UseActionToken(ctx context.Context, purpose, tokenHash string) (models.ActionToken, error) {
+	if err := ctx.Err(); err != nil {
+		return models.ActionToken{}, err
+	}
+
+	ptr.lock()
+	defer ptr.unlock()
+
+	for id, row := range ptr.actionTokens {
+		if row.TokenHash != tokenHash || row.Purpose != purpose {
+			continue
+		}
+
+		now := time.Now()
+		if row.UsedAt != nil || !now.Before(row.ExpiresAt) {
+			return models.ActionToken{}, global.ErrorTokenExpired
+		}
+
+		row.UsedAt = &now
+		ptr.actionTokens[id] = row
+		return row, nil
+	}
+
+	return models.ActionToken{}, global.ErrorTokenNotFound
+}
+
+func (ptr *Memory) RevokeActionTokens(ctx context.Context, userId, purpose string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ptr.lock()
+	defer ptr.unlock()
+
+	now := time.Now()
+	for id, row := range ptr.actionTokens {
+		if row.UserId.String() == userId && row.Purpose == purpose && row.UsedAt == nil {
+			row.UsedAt = &now
+			ptr.actionTokens[id] = row
+		}
+	}
+
+	return nil
+}
+
+func (ptr *Memory) ReplaceRecoveryCodes(ctx context.Context, userId string, hashes []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	id, err := uuid.Parse(userId)
+	if err != nil {
+		return global.ErrorInvalidFormat
+	}
+
+	ptr.lock()
+	defer ptr.unlock()
+
+	for codeId, row := range ptr.recoveryCodes {
+		if row.UserId == id {
+			delete(ptr.recoveryCodes, codeId)
+		}
+	}
+
+	now := time.Now()
+	for _, hash := range hashes {
+		row := models.RecoveryCode{Id: uuid.New(), UserId: id, CodeHash: hash, CreatedAt: now}
+		ptr.recoveryCodes[row.Id] = row
+	}
+
+	return nil
+}
+
+func (ptr *Memory) UseRecoveryCode(ctx context.Context, userId, hash string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ptr.lock()
+	defer ptr.unlock()
+
+	for id, row := range ptr.recoveryCodes {
+		if row.UserId.String() == userId && row.CodeHash == hash && row.UsedAt == nil {
+			now := time.Now()
+			row.UsedAt = &now
+			ptr.recoveryCodes[id] = row
+			return nil
+		}
+	}
+
+	return global.ErrorInvalidMFACode
+}
+
+func (ptr *Memory) CountRecoveryCodes(ctx context.Context, userId string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	ptr.rlock()
+	defer ptr.runlock()
+
+	var count int64
+	for _, row := range ptr.recoveryCodes {
+		if row.UserId.String() == userId && row.UsedAt == nil {
+			count++
+		}
+	}
+
+	return count, nil
+}
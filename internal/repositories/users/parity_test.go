@@ -0,0 +1,255 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-unitied-school/useragent/internal/interfaces"
+	"github.com/golang-unitied-school/useragent/internal/models"
+	global "github.com/golang-unitied-school/useragent/internal/pkg/utils"
+	"github.com/google/uuid"
+)
+
+func newTestStores(t *testing.T) map[string]interfaces.DataManager {
+	t.Helper()
+
+	memory := new(Memory)
+	memory.Init("")
+
+	lite := new(SQLite)
+	lite.Init(filepath.Join(t.TempDir(), "useragent.db"))
+	t.Cleanup(func() { lite.Close() })
+	if err := lite.Migrator().Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]interfaces.DataManager{"Memory": memory, "SQLite": lite}
+}
+
+// each step returns the error of one storage call; steps of a case share storage
+type parityCase struct {
+	name  string
+	steps []func(ctx context.Context, db interfaces.DataManager) error
+	// expected error of every step, nil for success
+	want []error
+}
+
+func newUser(ctx context.Context, db interfaces.DataManager, email string) (string, error) {
+	return db.Create(ctx, &models.User{Name: "Ivan", Surname: "Petrov", Email: email, Password: "hash"})
+}
+
+func TestMemorySQLiteParity(t *testing.T) {
+	var userId string
+	create := func(email string) func(ctx context.Context, db interfaces.DataManager) error {
+		return func(ctx context.Context, db interfaces.DataManager) (err error) {
+			userId, err = newUser(ctx, db, email)
+			return err
+		}
+	}
+	deleteUser := func(ctx context.Context, db interfaces.DataManager) error {
+		return db.Delete(ctx, userId, "")
+	}
+
+	cases := []parityCase{
+		{
+			name: "duplicate mixed-case email",
+			steps: []func(ctx context.Context, db interfaces.DataManager) error{
+				create("ivan@example.com"),
+				create("IVAN@Example.com"),
+			},
+			want: []error{nil, global.ErrorUserExists},
+		},
+		{
+			name: "unknown user",
+			steps: []func(ctx context.Context, db interfaces.DataManager) error{
+				func(ctx context.Context, db interfaces.DataManager) error {
+					_, err := db.GetById(ctx, uuid.NewString())
+					return err
+				},
+				func(ctx context.Context, db interfaces.DataManager) error {
+					_, err := db.GetByEmailWithDeleted(ctx, "nobody@example.com")
+					return err
+				},
+				func(ctx context.Context, db interfaces.DataManager) error {
+					_, err := db.Erase(ctx, uuid.NewString(), "", "")
+					return err
+				},
+				func(ctx context.Context, db interfaces.DataManager) error {
+					_, err := db.GetErasureReport(ctx, uuid.NewString())
+					return err
+				},
+				func(ctx context.Context, db interfaces.DataManager) error {
+					return db.SetLockout(ctx, uuid.NewString(), 0, 0, nil)
+				},
+			},
+			want: []error{global.ErrorUserNotFound, global.ErrorUserNotFound, global.ErrorUserNotFound, global.ErrorUserNotFound, global.ErrorUserNotFound},
+		},
+		{
+			name: "deleted user",
+			steps: []func(ctx context.Context, db interfaces.DataManager) error{
+				create("ivan@example.com"),
+				func(ctx context.Context, db interfaces.DataManager) error {
+					return db.Restore(ctx, userId)
+				},
+				func(ctx context.Context, db interfaces.DataManager) error {
+					_, err := db.Erase(ctx, userId, "", "")
+					return err
+				},
+				deleteUser,
+				func(ctx context.Context, db interfaces.DataManager) error {
+					_, err := db.GetById(ctx, userId)
+					return err
+				},
+				func(ctx context.Context, db interfaces.DataManager) error {
+					_, err := db.GetByIdWithDeleted(ctx, userId)
+					return err
+				},
+			},
+			want: []error{nil, global.ErrorUserNotFound, global.ErrorUserNotDeleted, nil, global.ErrorUserNotFound, nil},
+		},
+		{
+			name: "restore with reused email",
+			steps: []func(ctx context.Context, db interfaces.DataManager) error{
+				create("ivan@example.com"),
+				deleteUser,
+				func(ctx context.Context, db interfaces.DataManager) error {
+					deleted := userId
+					if _, err := newUser(ctx, db, "Ivan@example.com"); err != nil {
+						return err
+					}
+					return db.Restore(ctx, deleted)
+				},
+			},
+			want: []error{nil, nil, global.ErrorUserExists},
+		},
+		{
+			name: "erase deleted user",
+			steps: []func(ctx context.Context, db interfaces.DataManager) error{
+				create("ivan@example.com"),
+				deleteUser,
+				func(ctx context.Context, db interfaces.DataManager) error {
+					report, err := db.Erase(ctx, userId, "admin", models.ErasedAsAdmin)
+					if err == nil && report.ErasedBy != "admin" {
+						return errors.New("actor is not reported")
+					}
+					return err
+				},
+				func(ctx context.Context, db interfaces.DataManager) error {
+					report, err := db.GetErasureReport(ctx, userId)
+					if err == nil && (report.ErasedBy != "admin" || report.ErasedAs != models.ErasedAsAdmin || report.DeletedAt == nil) {
+						return errors.New("report is not stored")
+					}
+					return err
+				},
+				func(ctx context.Context, db interfaces.DataManager) error {
+					_, err := db.GetByIdWithDeleted(ctx, userId)
+					return err
+				},
+			},
+			want: []error{nil, nil, nil, nil, global.ErrorUserNotFound},
+		},
+		{
+			name: "refresh token reuse",
+			steps: []func(ctx context.Context, db interfaces.DataManager) error{
+				create("ivan@example.com"),
+				func(ctx context.Context, db interfaces.DataManager) error {
+					first := &models.RefreshToken{UserId: uuid.MustParse(userId), FamilyId: uuid.New(), TokenHash: "first", ExpiresAt: time.Now().Add(time.Hour)}
+					if err := db.CreateRefreshToken(ctx, first); err != nil {
+						return err
+					}
+					next := &models.RefreshToken{UserId: first.UserId, FamilyId: first.FamilyId, TokenHash: "second", ExpiresAt: first.ExpiresAt}
+					if err := db.RotateRefreshToken(ctx, first.Id.String(), next); err != nil {
+						return err
+					}
+					again := &models.RefreshToken{UserId: first.UserId, FamilyId: first.FamilyId, TokenHash: "third", ExpiresAt: first.ExpiresAt}
+					return db.RotateRefreshToken(ctx, first.Id.String(), again)
+				},
+				func(ctx context.Context, db interfaces.DataManager) error {
+					_, err := db.GetRefreshToken(ctx, "unknown")
+					return err
+				},
+			},
+			want: []error{nil, global.ErrorTokenReused, global.ErrorTokenNotFound},
+		},
+		{
+			name: "action token is single-use",
+			steps: []func(ctx context.Context, db interfaces.DataManager) error{
+				create("ivan@example.com"),
+				func(ctx context.Context, db interfaces.DataManager) error {
+					return db.CreateActionToken(ctx, &models.ActionToken{
+						UserId:    uuid.MustParse(userId),
+						Purpose:   models.ActionResetPassword,
+						TokenHash: "reset",
+						ExpiresAt: time.Now().Add(time.Hour),
+					})
+				},
+				func(ctx context.Context, db interfaces.DataManager) error {
+					_, err := db.UseActionToken(ctx, models.ActionVerifyEmail, "reset")
+					return err
+				},
+				func(ctx context.Context, db interfaces.DataManager) error {
+					_, err := db.UseActionToken(ctx, models.ActionResetPassword, "reset")
+					return err
+				},
+				func(ctx context.Context, db interfaces.DataManager) error {
+					_, err := db.UseActionToken(ctx, models.ActionResetPassword, "reset")
+					return err
+				},
+			},
+			want: []error{nil, nil, global.ErrorTokenNotFound, nil, global.ErrorTokenExpired},
+		},
+		{
+			name: "recovery codes and TOTP steps",
+			steps: []func(ctx context.Context, db interfaces.DataManager) error{
+				create("ivan@example.com"),
+				func(ctx context.Context, db interfaces.DataManager) error {
+					return db.ReplaceRecoveryCodes(ctx, userId, []string{"a", "b"})
+				},
+				func(ctx context.Context, db interfaces.DataManager) error {
+					return db.UseRecoveryCode(ctx, userId, "a")
+				},
+				func(ctx context.Context, db interfaces.DataManager) error {
+					return db.UseRecoveryCode(ctx, userId, "a")
+				},
+				func(ctx context.Context, db interfaces.DataManager) error {
+					return db.UseMFAStep(ctx, userId, 10)
+				},
+				func(ctx context.Context, db interfaces.DataManager) error {
+					return db.UseMFAStep(ctx, userId, 10)
+				},
+			},
+			want: []error{nil, nil, nil, global.ErrorInvalidMFACode, nil, global.ErrorInvalidMFACode},
+		},
+		{
+			name: "failed transaction is rolled back",
+			steps: []func(ctx context.Context, db interfaces.DataManager) error{
+				func(ctx context.Context, db interfaces.DataManager) error {
+					return db.WithTx(ctx, func(tx interfaces.DataManager) error {
+						if _, err := newUser(ctx, tx, "ivan@example.com"); err != nil {
+							return err
+						}
+						return global.ErrorNoNewData
+					})
+				},
+				create("ivan@example.com"),
+			},
+			want: []error{global.ErrorNoNewData, nil},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			for name, db := range newTestStores(t) {
+				ctx := context.Background()
+				for i, step := range tc.steps {
+					if err := step(ctx, db); !errors.Is(err, tc.want[i]) {
+						t.Errorf("%s: step %d: got %v, want %v", name, i, err, tc.want[i])
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package users
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/golang-unitied-school/useragent/internal/interfaces"
+	"github.com/golang-unitied-school/useragent/internal/models"
+	"github.com/golang-unitied-school/useragent/internal/repositories/migrations"
+	"github.com/google/uuid"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// SQLite storage for single-node deployments;
+// reuses PGSQL queries except for Postgres-only features
+type SQLite struct {
+	PGSQL
+}
+
+// connectionString is a path to database file
+func (ptr *SQLite) Init(connectionString string) {
+	var err error
+
+	ptr.dbConn, err = gorm.Open(sqlite.Open(connectionString), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+		// timestamps are stored as text, so keep them in one zone to compare correctly
+		NowFunc: func() time.Time { return time.Now().UTC() },
+	})
+
+	if err != nil {
+		log.Fatalf("error while starting connection: %s", err.Error())
+	}
+
+	// single connection serializes writers, so transactions never hit SQLITE_BUSY
+	sqlDB, err := ptr.dbConn.DB()
+	if err != nil {
+		log.Fatalf("error while getting db object: %s", err.Error())
+	}
+	sqlDB.SetMaxOpenConns(1)
+
+	ptr.migrator, err = migrations.New(ptr.dbConn, "sqlite")
+	if err != nil {
+		log.Fatalf("error while loading migrations: %s", err.Error())
+	}
+}
+
+func (ptr *SQLite) WithTx(ctx context.Context, fn func(tx interfaces.DataManager) error) error {
+	if ptr.inTx {
+		return fn(ptr)
+	}
+
+	return ptr.dbConn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(&SQLite{PGSQL{dbConn: tx, migrator: ptr.migrator, inTx: true}})
+	})
+}
+
+// id is generated here: uuid_generate_v4() exists only in Postgres
+func (ptr *SQLite) Create(ctx context.Context, user *models.User) (string, error) {
+	if user.Id == uuid.Nil {
+		user.Id = uuid.New()
+	}
+
+	return ptr.PGSQL.Create(ctx, user)
+}
+
+func (ptr *SQLite) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	if token.Id == uuid.Nil {
+		token.Id = uuid.New()
+	}
+
+	return ptr.PGSQL.CreateRefreshToken(ctx, token)
+}
+
+func (ptr *SQLite) CreateActionToken(ctx context.Context, token *models.ActionToken) error {
+	if token.Id == uuid.Nil {
+		token.Id = uuid.New()
+	}
+
+	return ptr.PGSQL.CreateActionToken(ctx, token)
+}
+
+func (ptr *SQLite) RotateRefreshToken(ctx context.Context, oldId string, next *models.RefreshToken) error {
+	if next.Id == uuid.Nil {
+		next.Id = uuid.New()
+	}
+
+	return ptr.PGSQL.RotateRefreshToken(ctx, oldId, next)
+}
+
+// no pg_trgm in SQLite: active users are ranked in memory, as in Memory storage
+func (ptr *SQLite) Search(ctx context.Context, query string, limit, offset int) ([]models.UserMatch, int64, error) {
+	var rows []models.User
+
+	res := ptr.dbConn.WithContext(ctx).Model(&User).Where("is_deleted = 0").Find(&rows)
+	if res.Error != nil {
+		return nil, 0, res.Error
+	}
+
+	matches, total := rankUsers(rows, query, limit, offset)
+	return matches, total, nil
+}
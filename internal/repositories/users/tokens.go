@@ -0,0 +1,208 @@
+package users
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang-unitied-school/useragent/internal/models"
+	global "github.com/golang-unitied-school/useragent/internal/pkg/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	RefreshToken models.RefreshToken
+	RevokedToken models.RevokedToken
+	ActionToken  models.ActionToken
+	RecoveryCode models.RecoveryCode
+)
+
+func (ptr *PGSQL) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	res := ptr.dbConn.WithContext(ctx).Create(token)
+	if res.Error != nil {
+		return res.Error
+	}
+
+	return nil
+}
+
+func (ptr *PGSQL) GetRefreshToken(ctx context.Context, tokenHash string) (models.RefreshToken, error) {
+	var row models.RefreshToken
+	res := ptr.dbConn.WithContext(ctx).Model(&RefreshToken).Where("token_hash = ?", tokenHash).First(&row)
+	if res.Error != nil {
+		if res.Error.Error() == global.ErrorRecordNotFound.Error() {
+			return row, global.ErrorTokenNotFound
+		} else {
+			return row, res.Error
+		}
+	}
+
+	return row, nil
+}
+
+// mark old token as used and store its successor in one transaction;
+// returns ErrorTokenReused if old token was already rotated or revoked
+func (ptr *PGSQL) RotateRefreshToken(ctx context.Context, oldId string, next *models.RefreshToken) error {
+	return ptr.dbConn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&RefreshToken).
+			Where("id = ? and rotated_at is null and revoked_at is null", oldId).
+			Update("rotated_at", time.Now())
+		if res.Error != nil {
+			return res.Error
+		}
+
+		if res.RowsAffected == 0 {
+			return global.ErrorTokenReused
+		}
+
+		return tx.Create(next).Error
+	})
+}
+
+func (ptr *PGSQL) RevokeRefreshFamily(ctx context.Context, familyId, actor string) error {
+	res := ptr.dbConn.WithContext(ctx).Model(&RefreshToken).
+		Where("family_id = ? and revoked_at is null", familyId).
+		UpdateColumns(map[string]interface{}{
+			"revoked_at": time.Now(),
+			"revoked_by": actor,
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+
+	return nil
+}
+
+func (ptr *PGSQL) RevokeUserRefreshTokens(ctx context.Context, userId string) error {
+	res := ptr.dbConn.WithContext(ctx).Model(&RefreshToken).
+		Where("user_id = ? and revoked_at is null", userId).
+		Update("revoked_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+
+	return nil
+}
+
+func (ptr *PGSQL) RevokeAccessToken(ctx context.Context, token *models.RevokedToken) error {
+	res := ptr.dbConn.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(token)
+	if res.Error != nil {
+		return res.Error
+	}
+
+	return nil
+}
+
+func (ptr *PGSQL) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	var count int64
+	res := ptr.dbConn.WithContext(ctx).Model(&RevokedToken).Where("jti = ?", jti).Count(&count)
+	if res.Error != nil {
+		return false, res.Error
+	}
+
+	return count > 0, nil
+}
+
+func (ptr *PGSQL) CreateActionToken(ctx context.Context, token *models.ActionToken) error {
+	res := ptr.dbConn.WithContext(ctx).Create(token)
+	if res.Error != nil {
+		return res.Error
+	}
+
+	return nil
+}
+
+// conditional update keeps token single-use under concurrent requests
+func (ptr *PGSQL) UseActionToken(ctx context.Context, purpose, tokenHash string) (models.ActionToken, error) {
+	var row models.ActionToken
+
+	err := ptr.dbConn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&ActionToken).Where("token_hash = ? and purpose = ?", tokenHash, purpose).First(&row)
+		if res.Error != nil {
+			if res.Error.Error() == global.ErrorRecordNotFound.Error() {
+				return global.ErrorTokenNotFound
+			}
+			return res.Error
+		}
+
+		now := time.Now()
+		if row.UsedAt != nil || !now.Before(row.ExpiresAt) {
+			return global.ErrorTokenExpired
+		}
+
+		res = tx.Model(&ActionToken).Where("id = ? and used_at is null", row.Id).Update("used_at", now)
+		if res.Error != nil {
+			return res.Error
+		}
+
+		if res.RowsAffected == 0 {
+			return global.ErrorTokenExpired
+		}
+
+		row.UsedAt = &now
+		return nil
+	})
+
+	return row, err
+}
+
+func (ptr *PGSQL) RevokeActionTokens(ctx context.Context, userId, purpose string) error {
+	res := ptr.dbConn.WithContext(ctx).Model(&ActionToken).
+		Where("user_id = ? and purpose = ? and used_at is null", userId, purpose).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+
+	return nil
+}
+
+func (ptr *PGSQL) ReplaceRecoveryCodes(ctx context.Context, userId string, hashes []string) error {
+	id, err := uuid.Parse(userId)
+	if err != nil {
+		return global.ErrorInvalidFormat
+	}
+
+	return ptr.dbConn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userId).Delete(&RecoveryCode).Error; err != nil {
+			return err
+		}
+
+		if len(hashes) == 0 {
+			return nil
+		}
+
+		rows := make([]models.RecoveryCode, 0, len(hashes))
+		for _, hash := range hashes {
+			rows = append(rows, models.RecoveryCode{Id: uuid.New(), UserId: id, CodeHash: hash})
+		}
+
+		return tx.Create(&rows).Error
+	})
+}
+
+func (ptr *PGSQL) UseRecoveryCode(ctx context.Context, userId, hash string) error {
+	res := ptr.dbConn.WithContext(ctx).Model(&RecoveryCode).
+		Where("user_id = ? and code_hash = ? and used_at is null", userId, hash).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		return global.ErrorInvalidMFACode
+	}
+
+	return nil
+}
+
+func (ptr *PGSQL) CountRecoveryCodes(ctx context.Context, userId string) (int64, error) {
+	var count int64
+	res := ptr.dbConn.WithContext(ctx).Model(&RecoveryCode).Where("user_id = ? and used_at is null", userId).Count(&count)
+	if res.Error != nil {
+		return 0, res.Error
+	}
+
+	return count, nil
+}
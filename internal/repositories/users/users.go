@@ -1,21 +1,43 @@
 package users
 
 import (
+	"context"
 	"log"
 	"strings"
+	"time"
 
+	"github.com/golang-unitied-school/useragent/internal/interfaces"
 	"github.com/golang-unitied-school/useragent/internal/models"
 	global "github.com/golang-unitied-school/useragent/internal/pkg/utils"
+	"github.com/golang-unitied-school/useragent/internal/repositories/migrations"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 )
 
 type PGSQL struct {
-	dbConn *gorm.DB
+	dbConn   *gorm.DB
+	migrator *migrations.Migrator
+	// set for storage handed to WithTx callback
+	inTx bool
 }
 
-var User models.User
+var (
+	User          models.User
+	ErasureReport models.ErasureReport
+)
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// prefix matches rank above any fuzzy match;
+// LIKE and % are served by trigram indexes from 0004_add_users_search_indexes
+const searchRank = `GREATEST(similarity(lower(name), @q), similarity(lower(surname), @q), similarity(lower(email), @q))
+	+ CASE WHEN lower(name) LIKE @prefix OR lower(surname) LIKE @prefix OR lower(email) LIKE @prefix THEN 1 ELSE 0 END`
+
+const searchCondition = `is_deleted = 0 AND (
+	lower(name) LIKE @prefix OR lower(surname) LIKE @prefix OR lower(email) LIKE @prefix
+	OR lower(name) % @q OR lower(surname) % @q OR lower(email) % @q)`
 
 func (ptr *PGSQL) Init(connectionString string) {
 	var err error
@@ -28,27 +50,55 @@ func (ptr *PGSQL) Init(connectionString string) {
 		log.Fatalf("error while starting connection: %s", err.Error())
 	}
 
-	log.Println("trying to migrate schema...")
-	if err = ptr.dbConn.AutoMigrate(&User); err != nil {
-		log.Fatalf("error while migrating: %s", err.Error())
+	ptr.migrator, err = migrations.New(ptr.dbConn, "postgres")
+	if err != nil {
+		log.Fatalf("error while loading migrations: %s", err.Error())
+	}
+}
+
+func (ptr *PGSQL) Migrator() *migrations.Migrator {
+	return ptr.migrator
+}
+
+// run fn in one read committed transaction; rows read by id or email inside it
+// are locked until commit, so read-then-write sequences do not interleave
+func (ptr *PGSQL) WithTx(ctx context.Context, fn func(tx interfaces.DataManager) error) error {
+	if ptr.inTx {
+		return fn(ptr)
+	}
+
+	return ptr.dbConn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(&PGSQL{dbConn: tx, migrator: ptr.migrator, inTx: true})
+	})
+}
+
+func (ptr *PGSQL) forUpdate(db *gorm.DB) *gorm.DB {
+	if !ptr.inTx {
+		return db
 	}
+
+	return db.Clauses(clause.Locking{Strength: "UPDATE"})
 }
 
-func (ptr *PGSQL) Create(user *models.User) (string, error) {
+func (ptr *PGSQL) Create(ctx context.Context, user *models.User) (string, error) {
 
-	newRow := ptr.dbConn.Create(user)
+	user.EmailNormalized = global.NormalizeEmail(user.Email)
+	newRow := ptr.dbConn.WithContext(ctx).Create(user)
 
 	if newRow.Error != nil {
+		if isUniqueViolation(newRow.Error) {
+			return "", global.ErrorUserExists
+		}
 		return "", newRow.Error
 	}
 
 	return user.Id.String(), nil
 }
-func (ptr *PGSQL) Update(uuid, fname, sname, email, role string) error {
+func (ptr *PGSQL) Update(ctx context.Context, uuid, fname, sname, email, role string) error {
 
 	var row, newRow models.User
 
-	res := ptr.dbConn.Model(&User).Where("id = ? and is_deleted = 0", uuid).First(&row)
+	res := ptr.forUpdate(ptr.dbConn.WithContext(ctx)).Model(&User).Where("id = ? and is_deleted = 0", uuid).First(&row)
 	if res.Error != nil {
 		return res.Error
 	}
@@ -67,6 +117,7 @@ func (ptr *PGSQL) Update(uuid, fname, sname, email, role string) error {
 
 	if !strings.EqualFold(row.Email, email) && email != "" {
 		newRow.Email = email
+		newRow.EmailNormalized = global.NormalizeEmail(email)
 		changes++
 	}
 
@@ -80,28 +131,155 @@ func (ptr *PGSQL) Update(uuid, fname, sname, email, role string) error {
 	}
 
 	newRow.Id = row.Id
-	res = ptr.dbConn.Model(&newRow).Updates(&newRow)
+	res = ptr.dbConn.WithContext(ctx).Model(&newRow).Updates(&newRow)
+	if res.Error != nil {
+		if isUniqueViolation(res.Error) {
+			return global.ErrorUserExists
+		}
+		return res.Error
+	}
+
+	return nil
+}
+func (ptr *PGSQL) Delete(ctx context.Context, userId, actor string) error {
+	res := ptr.dbConn.WithContext(ctx).Model(&User).Where("id = ? and is_deleted = 0", userId).UpdateColumns(map[string]interface{}{
+		"is_deleted": 1,
+		"deleted_at": time.Now(),
+		"deleted_by": actor,
+	})
 	if res.Error != nil {
 		return res.Error
 	}
 
 	return nil
 }
-func (ptr *PGSQL) Delete(userId string) error {
-	var row models.User
-	row.IsDeleted = 1
 
-	res := ptr.dbConn.Model(&User).Where("id = ?", userId).Updates(&row)
+func (ptr *PGSQL) Restore(ctx context.Context, userId string) error {
+	res := ptr.dbConn.WithContext(ctx).Model(&User).Where("id = ? and is_deleted = 1", userId).UpdateColumns(map[string]interface{}{
+		"is_deleted": 0,
+		"deleted_at": nil,
+		"deleted_by": "",
+	})
 	if res.Error != nil {
+		if isUniqueViolation(res.Error) {
+			return global.ErrorUserExists
+		}
 		return res.Error
 	}
 
+	if res.RowsAffected == 0 {
+		return global.ErrorUserNotFound
+	}
+
 	return nil
 }
 
-func (ptr *PGSQL) GetById(userId string) (models.User, error) {
+func (ptr *PGSQL) Erase(ctx context.Context, userId, actor, as string) (models.ErasureReport, error) {
+	report := models.ErasureReport{ErasedBy: actor, ErasedAs: as}
+
+	err := ptr.dbConn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var row models.User
+		res := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Model(&User).Where("id = ?", userId).First(&row)
+		if res.Error != nil {
+			if res.Error.Error() == global.ErrorRecordNotFound.Error() {
+				return global.ErrorUserNotFound
+			}
+			return res.Error
+		}
+
+		if row.IsDeleted == 0 {
+			return global.ErrorUserNotDeleted
+		}
+
+		report.UserId, report.DeletedAt = row.Id, row.DeletedAt
+
+		counts := []struct {
+			model interface{}
+			count *int64
+		}{
+			{&RefreshToken, &report.RefreshTokens},
+			{&RevokedToken, &report.RevokedTokens},
+			{&ActionToken, &report.ActionTokens},
+			{&RecoveryCode, &report.RecoveryCodes},
+		}
+		for _, item := range counts {
+			res = tx.Where("user_id = ?", userId).Delete(item.model)
+			if res.Error != nil {
+				return res.Error
+			}
+			*item.count = res.RowsAffected
+		}
+
+		if err := tx.Where("id = ?", userId).Delete(&User).Error; err != nil {
+			return err
+		}
+
+		report.ErasedAt = time.Now()
+		return tx.Create(&report).Error
+	})
+	if err != nil {
+		return models.ErasureReport{}, err
+	}
+
+	return report, nil
+}
+
+func (ptr *PGSQL) GetErasureReport(ctx context.Context, userId string) (models.ErasureReport, error) {
+	var row models.ErasureReport
+	res := ptr.dbConn.WithContext(ctx).Model(&ErasureReport).Where("user_id = ?", userId).First(&row)
+	if res.Error != nil {
+		if res.Error.Error() == global.ErrorRecordNotFound.Error() {
+			return row, global.ErrorUserNotFound
+		}
+		return row, res.Error
+	}
+
+	return row, nil
+}
+
+func (ptr *PGSQL) GetUserData(ctx context.Context, userId string) (models.UserData, error) {
+	var data models.UserData
+
+	user, err := ptr.GetByIdWithDeleted(ctx, userId)
+	if err != nil {
+		return data, err
+	}
+	data.User = user
+
+	db := ptr.dbConn.WithContext(ctx)
+	if err = db.Where("user_id = ?", userId).Order("created_at, id").Find(&data.RefreshTokens).Error; err != nil {
+		return data, err
+	}
+	if err = db.Where("user_id = ?", userId).Order("revoked_at, jti").Find(&data.RevokedTokens).Error; err != nil {
+		return data, err
+	}
+	if err = db.Where("user_id = ?", userId).Order("created_at, id").Find(&data.ActionTokens).Error; err != nil {
+		return data, err
+	}
+	if err = db.Where("user_id = ?", userId).Order("created_at, id").Find(&data.RecoveryCodes).Error; err != nil {
+		return data, err
+	}
+
+	return data, nil
+}
+
+func (ptr *PGSQL) ListDeletedBefore(ctx context.Context, before time.Time, limit, offset int) ([]models.User, error) {
+	var rows []models.User
+	res := ptr.dbConn.WithContext(ctx).Model(&User).
+		Where("is_deleted = 1 and deleted_at < ?", before).
+		Order("deleted_at, id").
+		Limit(limit).Offset(offset).
+		Find(&rows)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return rows, nil
+}
+
+func (ptr *PGSQL) GetById(ctx context.Context, userId string) (models.User, error) {
 	var row models.User
-	res := ptr.dbConn.Model(&User).Where("id = ? and is_deleted = 0", userId).First(&row)
+	res := ptr.forUpdate(ptr.dbConn.WithContext(ctx)).Model(&User).Where("id = ? and is_deleted = 0", userId).First(&row)
 	if res.Error != nil {
 		if res.Error.Error() == global.ErrorRecordNotFound.Error() {
 			return row, global.ErrorUserNotFound
@@ -113,9 +291,9 @@ func (ptr *PGSQL) GetById(userId string) (models.User, error) {
 
 	return row, nil
 }
-func (ptr *PGSQL) GetByEmail(email string) (models.User, error) {
+func (ptr *PGSQL) GetByEmail(ctx context.Context, email string) (models.User, error) {
 	var row models.User
-	res := ptr.dbConn.Model(&User).Where("email = ?", email).First(&row)
+	res := ptr.forUpdate(ptr.dbConn.WithContext(ctx)).Model(&User).Where("email_normalized = ? and is_deleted = 0", global.NormalizeEmail(email)).First(&row)
 
 	if res.Error != nil {
 		if res.Error.Error() == global.ErrorRecordNotFound.Error() {
@@ -128,9 +306,119 @@ func (ptr *PGSQL) GetByEmail(email string) (models.User, error) {
 	return row, nil
 }
 
-func (ptr *PGSQL) GetPassword(userId string) (string, error) {
+func (ptr *PGSQL) GetByIdWithDeleted(ctx context.Context, userId string) (models.User, error) {
+	var row models.User
+	res := ptr.forUpdate(ptr.dbConn.WithContext(ctx)).Model(&User).Where("id = ?", userId).First(&row)
+	if res.Error != nil {
+		if res.Error.Error() == global.ErrorRecordNotFound.Error() {
+			return row, global.ErrorUserNotFound
+		}
+		return row, res.Error
+	}
+
+	return row, nil
+}
+
+func (ptr *PGSQL) GetByEmailWithDeleted(ctx context.Context, email string) (models.User, error) {
+	var row models.User
+	res := ptr.forUpdate(ptr.dbConn.WithContext(ctx)).Model(&User).
+		Where("email_normalized = ?", global.NormalizeEmail(email)).
+		Order("is_deleted, created_at desc").
+		First(&row)
+	if res.Error != nil {
+		if res.Error.Error() == global.ErrorRecordNotFound.Error() {
+			return row, global.ErrorUserNotFound
+		}
+		return row, res.Error
+	}
+
+	return row, nil
+}
+
+// keyset pagination over (created_at, id), served by idx_users_created_id;
+// total is counted with filters but without cursor
+func (ptr *PGSQL) List(ctx context.Context, query models.ListUsersQuery) ([]models.User, int64, error) {
+	var (
+		rows  []models.User
+		total int64
+	)
+
+	filtered := ptr.dbConn.WithContext(ctx).Model(&User)
+	if query.Role != "" {
+		filtered = filtered.Where("role = ?", query.Role)
+	}
+	if query.IsDeleted != nil {
+		filtered = filtered.Where("is_deleted = ?", *query.IsDeleted)
+	}
+	if !query.CreatedFrom.IsZero() {
+		filtered = filtered.Where("created_at >= ?", query.CreatedFrom)
+	}
+	if !query.CreatedTo.IsZero() {
+		filtered = filtered.Where("created_at < ?", query.CreatedTo)
+	}
+
+	res := filtered.Session(&gorm.Session{}).Count(&total)
+	if res.Error != nil {
+		return nil, 0, res.Error
+	}
+
+	page := filtered.Session(&gorm.Session{})
+	order := "created_at desc, id desc"
+	if query.Ascending {
+		order = "created_at asc, id asc"
+	}
+
+	if query.After != nil {
+		if query.Ascending {
+			page = page.Where("(created_at, id) > (?, ?)", query.After.CreatedAt, query.After.Id)
+		} else {
+			page = page.Where("(created_at, id) < (?, ?)", query.After.CreatedAt, query.After.Id)
+		}
+	}
+
+	res = page.Order(order).Limit(query.Limit).Find(&rows)
+	if res.Error != nil {
+		return nil, 0, res.Error
+	}
+
+	return rows, total, nil
+}
+
+// case-insensitive prefix and trigram search over active users, ordered by rank
+func (ptr *PGSQL) Search(ctx context.Context, query string, limit, offset int) ([]models.UserMatch, int64, error) {
+	var (
+		rows  []models.UserMatch
+		total int64
+	)
+
+	q := strings.ToLower(strings.TrimSpace(query))
+	args := map[string]interface{}{
+		"q":      q,
+		"prefix": likeEscaper.Replace(q) + "%",
+	}
+
+	res := ptr.dbConn.WithContext(ctx).Model(&User).Where(searchCondition, args).Count(&total)
+	if res.Error != nil {
+		return nil, 0, res.Error
+	}
+
+	res = ptr.dbConn.WithContext(ctx).Model(&User).
+		Select("users.*, "+searchRank+" AS rank", args).
+		Where(searchCondition, args).
+		Order("rank desc, id").
+		Limit(limit).
+		Offset(offset).
+		Scan(&rows)
+	if res.Error != nil {
+		return nil, 0, res.Error
+	}
+
+	return rows, total, nil
+}
+
+func (ptr *PGSQL) GetPassword(ctx context.Context, userId string) (string, error) {
 	var row models.User
-	res := ptr.dbConn.Model(&User).Where("id = ? and is_deleted = 0", userId).First(&row)
+	res := ptr.forUpdate(ptr.dbConn.WithContext(ctx)).Model(&User).Where("id = ? and is_deleted = 0", userId).First(&row)
 	if res.Error != nil {
 		return "", res.Error
 	}
@@ -138,19 +426,77 @@ func (ptr *PGSQL) GetPassword(userId string) (string, error) {
 	return row.Password, nil
 }
 
-func (ptr *PGSQL) SetPassword(userId, newPass string) error {
+func (ptr *PGSQL) SetPassword(ctx context.Context, userId, hash string) error {
 	var row models.User
 
-	hash, err := global.EncodingPassword(newPass)
-	if err != nil {
-		return err
+	res := ptr.dbConn.WithContext(ctx).Model(&User).Where("id = ? and is_deleted = 0", userId).First(&row).UpdateColumn("password", hash)
+	if res.Error != nil {
+		return res.Error
+	}
+
+	return nil
+}
+
+func (ptr *PGSQL) SetEmailVerified(ctx context.Context, userId string, verified bool) error {
+	res := ptr.dbConn.WithContext(ctx).Model(&User).Where("id = ? and is_deleted = 0", userId).UpdateColumn("email_verified", verified)
+	if res.Error != nil {
+		return res.Error
 	}
 
-	res := ptr.dbConn.Model(&User).Where("id = ? and is_deleted = 0", userId).First(&row).UpdateColumn("password", hash)
+	if res.RowsAffected == 0 {
+		return global.ErrorUserNotFound
+	}
+
+	return nil
+}
+
+func (ptr *PGSQL) SetMFA(ctx context.Context, userId, secret string, enabled bool, lastStep int64) error {
+	res := ptr.dbConn.WithContext(ctx).Model(&User).Where("id = ? and is_deleted = 0", userId).UpdateColumns(map[string]interface{}{
+		"totp_secret":   secret,
+		"mfa_enabled":   enabled,
+		"mfa_last_step": lastStep,
+	})
 	if res.Error != nil {
 		return res.Error
 	}
 
+	if res.RowsAffected == 0 {
+		return global.ErrorUserNotFound
+	}
+
+	return nil
+}
+
+func (ptr *PGSQL) SetLockout(ctx context.Context, userId string, failedLogins, lockouts int32, lockedUntil *time.Time) error {
+	res := ptr.dbConn.WithContext(ctx).Model(&User).Where("id = ? and is_deleted = 0", userId).UpdateColumns(map[string]interface{}{
+		"failed_logins": failedLogins,
+		"lockouts":      lockouts,
+		"locked_until":  lockedUntil,
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		return global.ErrorUserNotFound
+	}
+
+	return nil
+}
+
+// conditional update rejects replay of code under concurrent logins
+func (ptr *PGSQL) UseMFAStep(ctx context.Context, userId string, step int64) error {
+	res := ptr.dbConn.WithContext(ctx).Model(&User).
+		Where("id = ? and mfa_last_step < ?", userId, step).
+		UpdateColumn("mfa_last_step", step)
+	if res.Error != nil {
+		return res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		return global.ErrorInvalidMFACode
+	}
+
 	return nil
 }
 